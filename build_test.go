@@ -0,0 +1,122 @@
+package terraform
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	environs "github.com/zen-io/zen-core/environments"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/golden"
+)
+
+// snapshotTree renders every file under root with its contents, and symlinks with their destination, in path order
+func snapshotTree(t *testing.T, root string) string {
+	t.Helper()
+
+	var sb strings.Builder
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root || d.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		if d.Type()&fs.ModeSymlink != 0 {
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(dest) {
+				dest, _ = filepath.Rel(root, dest)
+			}
+			fmt.Fprintf(&sb, "== %s -> %s\n", filepath.ToSlash(rel), filepath.ToSlash(dest))
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sb, "== %s\n%s", filepath.ToSlash(rel), data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			sb.WriteString("\n")
+		}
+
+		return nil
+	})
+	assert.NilError(t, err)
+
+	return sb.String()
+}
+
+// copyFixture copies a fixture project into a new sandbox
+func copyFixture(t *testing.T, fixture string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	src := filepath.Join("testdata", "build", fixture)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		writeFiles(t, root, map[string]string{rel: string(data)})
+
+		return nil
+	})
+	assert.NilError(t, err)
+
+	return root
+}
+
+func TestBuildLayout(t *testing.T) {
+	backend := "backend.tf"
+
+	for name, tc := range map[string]TerraformConfig{
+		"envs": {
+			Name:    "infra",
+			Srcs:    []string{"*.tf", "*.tfvars", "*.tfvars.json", "vars/*"},
+			EnvSrcs: map[string][]string{"prod": {"overlays/prod/*"}},
+			Environments: map[string]*environs.Environment{
+				"dev":  {Variables: map[string]string{"TERRAFORM_BACKEND": "backends/s3.tf", "REGION": "eu-west-1"}},
+				"prod": {Variables: map[string]string{"TERRAFORM_BACKEND": "backends/s3.tf", "REGION": "eu-central-1", "TERRAFORM_VAR_instance_count": "3"}},
+			},
+			Vars: map[string]interface{}{
+				"instance_count": 1,
+				"instance_types": []interface{}{"t3.small", "t3.medium"},
+				"labels":         map[string]interface{}{"env": "{DEPLOY_ENV}", "region": "{REGION}"},
+			},
+			TerraformDeploymentConfig: TerraformDeploymentConfig{
+				VarFiles:        []string{"common.tfvars", "vars/{DEPLOY_ENV}.tfvars", "tags.tfvars.json"},
+				Modules:         []string{"modules/network"},
+				ProviderConfigs: []string{"providers/aws.tf"},
+			},
+		},
+		"single": {
+			Name: "single",
+			Srcs: []string{"*.tf", "terraform.tfvars"},
+			TerraformDeploymentConfig: TerraformDeploymentConfig{
+				Backend:  &backend,
+				VarFiles: []string{"terraform.tfvars"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tb := getTarget(t, tc)
+			root := copyFixture(t, name)
+			assert.NilError(t, runScript(t, tb, root, "build", &zen_targets.RuntimeContext{}))
+
+			// run go test -run TestBuildLayout -update to accept a layout change
+			golden.Assert(t, snapshotTree(t, root), filepath.Join("build", name+".golden"))
+		})
+	}
+}
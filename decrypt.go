@@ -0,0 +1,489 @@
+package terraform
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// encryptedDir is where build places the encrypted var files of an env. They are only decrypted next to it
+// while a script runs, so that plain secrets never end up in the build outputs
+const encryptedDir = ".zen_encrypted"
+
+// defaultAgeKeyEnv is the environment variable holding the age identities, the same one sops reads
+const defaultAgeKeyEnv = "SOPS_AGE_KEY"
+
+// defaultUnencryptedSuffix is the suffix of the keys sops leaves unencrypted when the document sets no other rule
+const defaultUnencryptedSuffix = "_unencrypted"
+
+var sopsValueRegex = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+type sopsAgeRecipient struct {
+	Recipient string `json:"recipient"`
+	Enc       string `json:"enc"`
+}
+
+type sopsMetadata struct {
+	Age               []sopsAgeRecipient `json:"age"`
+	LastModified      string             `json:"lastmodified"`
+	MAC               string             `json:"mac"`
+	UnencryptedSuffix string             `json:"unencrypted_suffix"`
+	EncryptedSuffix   string             `json:"encrypted_suffix"`
+	UnencryptedRegex  string             `json:"unencrypted_regex"`
+	EncryptedRegex    string             `json:"encrypted_regex"`
+	MACOnlyEncrypted  bool               `json:"mac_only_encrypted"`
+}
+
+// sopsBranch is an object of a sops document, keeping the order of its keys
+type sopsBranch []sopsItem
+
+type sopsItem struct {
+	Key   string
+	Value interface{}
+}
+
+// isSopsFile tells whether the file is a sops encrypted document, json or sops' binary format
+func isSopsFile(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+
+	_, ok := doc["sops"]
+	return ok
+}
+
+func decryptAge(data []byte, identities []age.Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// decryptSops decrypts a sops document whose data key is encrypted for age. Every value is authenticated by
+// AES-GCM with its path and the document as a whole by its MAC, so values cannot be added, swapped or removed
+func decryptSops(data []byte, identities []age.Identity) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("decoding sops document: %w", err)
+	}
+
+	var meta sopsMetadata
+	if err := json.Unmarshal(raw["sops"], &meta); err != nil {
+		return nil, fmt.Errorf("decoding sops metadata: %w", err)
+	}
+	encrypted, err := meta.encryptedPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	// the MAC depends on the order of the values, which a map would lose
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeSopsValue(dec)
+	if err != nil {
+		return nil, fmt.Errorf("decoding sops document: %w", err)
+	}
+	doc := sopsBranch{}
+	for _, item := range value.(sopsBranch) {
+		if item.Key != "sops" {
+			doc = append(doc, item)
+		}
+	}
+
+	var dataKey []byte
+	for _, r := range meta.Age {
+		if key, err := decryptAge([]byte(r.Enc), identities); err == nil {
+			dataKey = key
+			break
+		}
+	}
+	if dataKey == nil {
+		return nil, fmt.Errorf("none of the age identities can decrypt the sops data key")
+	}
+
+	sd := &sopsDecrypter{key: dataKey, encrypted: encrypted, macOnlyEncrypted: meta.MACOnlyEncrypted, hash: sha512.New()}
+	plain, err := sd.decrypt(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := sd.verify(meta); err != nil {
+		return nil, err
+	}
+
+	// sops' binary format keeps the whole file under data
+	if b := plain.(sopsBranch); len(b) == 1 && b[0].Key == "data" {
+		if s, ok := b[0].Value.(string); ok {
+			return []byte(s), nil
+		}
+	}
+
+	return json.MarshalIndent(plain, "", "  ")
+}
+
+// encryptedPaths returns whether the value at a path is meant to be encrypted, following the rules the document was
+// encrypted with
+func (m *sopsMetadata) encryptedPaths() (func(path []string) bool, error) {
+	anyKey := func(path []string, match func(string) bool) bool {
+		for _, k := range path {
+			if match(k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case m.UnencryptedSuffix != "":
+		return func(path []string) bool {
+			return !anyKey(path, func(k string) bool { return strings.HasSuffix(k, m.UnencryptedSuffix) })
+		}, nil
+	case m.EncryptedSuffix != "":
+		return func(path []string) bool {
+			return anyKey(path, func(k string) bool { return strings.HasSuffix(k, m.EncryptedSuffix) })
+		}, nil
+	case m.UnencryptedRegex != "":
+		re, err := regexp.Compile(m.UnencryptedRegex)
+		if err != nil {
+			return nil, fmt.Errorf("compiling sops unencrypted_regex: %w", err)
+		}
+		return func(path []string) bool { return !anyKey(path, re.MatchString) }, nil
+	case m.EncryptedRegex != "":
+		re, err := regexp.Compile(m.EncryptedRegex)
+		if err != nil {
+			return nil, fmt.Errorf("compiling sops encrypted_regex: %w", err)
+		}
+		return func(path []string) bool { return anyKey(path, re.MatchString) }, nil
+	default:
+		return func(path []string) bool {
+			return !anyKey(path, func(k string) bool { return strings.HasSuffix(k, defaultUnencryptedSuffix) })
+		}, nil
+	}
+}
+
+// sopsDecrypter decrypts the values of a document, hashing them in order for its MAC
+type sopsDecrypter struct {
+	key              []byte
+	encrypted        func(path []string) bool
+	macOnlyEncrypted bool
+	hash             hash.Hash
+}
+
+func (sd *sopsDecrypter) decrypt(value interface{}, path []string) (interface{}, error) {
+	switch v := value.(type) {
+	case sopsBranch:
+		branch := sopsBranch{}
+		for _, item := range v {
+			decrypted, err := sd.decrypt(item.Value, append(append([]string{}, path...), item.Key))
+			if err != nil {
+				return nil, err
+			}
+			branch = append(branch, sopsItem{Key: item.Key, Value: decrypted})
+		}
+		return branch, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			decrypted, err := sd.decrypt(item, path)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = decrypted
+		}
+		return list, nil
+	}
+
+	if !sd.encrypted(path) {
+		if !sd.macOnlyEncrypted {
+			sd.hash.Write(sopsBytes(value))
+		}
+		return value, nil
+	}
+
+	s, _ := value.(string)
+	match := sopsValueRegex.FindStringSubmatch(s)
+	if match == nil && s != "" {
+		return nil, fmt.Errorf("sops value of %s is not encrypted", strings.Join(path, ":"))
+	}
+
+	var plain interface{} = s
+	if match != nil {
+		var err error
+		if plain, err = decryptSopsLeaf(match, sd.key, strings.Join(path, ":")+":"); err != nil {
+			return nil, err
+		}
+	}
+	sd.hash.Write(sopsBytes(plain))
+
+	return plain, nil
+}
+
+// verify checks the MAC of the values decrypted against the one in the metadata
+func (sd *sopsDecrypter) verify(meta sopsMetadata) error {
+	match := sopsValueRegex.FindStringSubmatch(meta.MAC)
+	if match == nil {
+		return fmt.Errorf("sops document has no MAC")
+	}
+
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("parsing sops lastmodified: %w", err)
+	}
+
+	mac, err := decryptSopsLeaf(match, sd.key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("decrypting sops MAC: %w", err)
+	}
+
+	computed := strings.ToUpper(hex.EncodeToString(sd.hash.Sum(nil)))
+	if s, ok := mac.(string); !ok || subtle.ConstantTimeCompare([]byte(s), []byte(computed)) != 1 {
+		return fmt.Errorf("sops MAC mismatch, the document was modified after it was encrypted")
+	}
+
+	return nil
+}
+
+// sopsBytes returns the bytes of a value sops hashes into the MAC
+func sopsBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	default:
+		return nil
+	}
+}
+
+// decodeSopsValue decodes the next json value, keeping objects as ordered branches
+func decodeSopsValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			list := []interface{}{}
+			for dec.More() {
+				item, err := decodeSopsValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+
+		branch := sopsBranch{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeSopsValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			branch = append(branch, sopsItem{Key: key.(string), Value: value})
+		}
+		_, err := dec.Token()
+		return branch, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i), nil
+		}
+		return t.Float64()
+	default:
+		return tok, nil
+	}
+}
+
+func (b sopsBranch) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, item := range b {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func decryptSopsLeaf(match []string, key []byte, additionalData string) (interface{}, error) {
+	parts := [][]byte{}
+	for _, p := range match[1:4] {
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			return nil, fmt.Errorf("decoding sops value of %s: %w", additionalData, err)
+		}
+		parts = append(parts, b)
+	}
+	ciphertext, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting sops value of %s: %w", additionalData, err)
+	}
+
+	switch match[4] {
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	default:
+		return string(plain), nil
+	}
+}
+
+// decryptVarFiles decrypts the encrypted var files build placed in dir next to it, with the age identities in the
+// keyEnv environment variable of the target. The returned cleanup removes the decrypted files
+func decryptVarFiles(target *zen_targets.Target, dir, keyEnv string) (func(), error) {
+	cleanup := func() {}
+
+	entries, err := os.ReadDir(filepath.Join(dir, encryptedDir))
+	if os.IsNotExist(err) {
+		return cleanup, nil
+	} else if err != nil {
+		return cleanup, fmt.Errorf("reading encrypted var files: %w", err)
+	}
+
+	// a run that crashed or was killed leaves its decrypted files behind, never reuse them
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return cleanup, fmt.Errorf("removing stale decrypted %s: %w", entry.Name(), err)
+		}
+	}
+
+	if keyEnv == "" {
+		keyEnv = defaultAgeKeyEnv
+	}
+	keys := target.EnvVars()[keyEnv]
+	if keys == "" {
+		return cleanup, fmt.Errorf("%s is not set, pass it with pass_secret_env to decrypt the var files", keyEnv)
+	}
+
+	identities, err := age.ParseIdentities(strings.NewReader(keys))
+	if err != nil {
+		return cleanup, fmt.Errorf("parsing the age identities in %s: %w", keyEnv, err)
+	}
+
+	written := []string{}
+	cleanup = func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(dir, encryptedDir, entry.Name())
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return cleanup, fmt.Errorf("reading %s: %w", entry.Name(), err)
+		}
+
+		var plain []byte
+		if isSopsFile(src) {
+			plain, err = decryptSops(data, identities)
+		} else {
+			plain, err = decryptAge(data, identities)
+		}
+		if err != nil {
+			return cleanup, fmt.Errorf("decrypting %s: %w", entry.Name(), err)
+		}
+
+		to := filepath.Join(dir, entry.Name())
+		if err := os.WriteFile(to, plain, 0600); err != nil {
+			return cleanup, fmt.Errorf("writing %s: %w", entry.Name(), err)
+		}
+		written = append(written, to)
+
+		// the decrypted values are secrets, keep them out of the logs
+		values, err := parseVarFile(hclparse.NewParser(), to)
+		if err != nil {
+			return cleanup, err
+		}
+		for _, v := range values {
+			secretsOf(target).addCty(v)
+		}
+	}
+
+	return cleanup, nil
+}
+
+// withDecryptedVars runs fn with the encrypted var files of the env decrypted, with the identities in the keyEnv of the env
+func withDecryptedVars(keyEnv func(env string) string, fn func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error) func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	return func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+		cleanup, err := decryptVarFiles(target, target.Cwd, keyEnv(runCtx.Env))
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+
+		return fn(target, runCtx)
+	}
+}
@@ -0,0 +1,266 @@
+package terraform
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func encryptAge(t *testing.T, recipient age.Recipient, plain []byte, armored bool) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	var dst io.Writer = &out
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&out)
+		dst = aw
+	}
+
+	w, err := age.Encrypt(dst, recipient)
+	assert.NilError(t, err)
+	_, err = w.Write(plain)
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	if aw != nil {
+		assert.NilError(t, aw.Close())
+	}
+
+	return out.Bytes()
+}
+
+// sopsEncryptValue encrypts a value the way sops does, authenticated with the additional data
+func sopsEncryptValue(t *testing.T, key []byte, plain, typ, additionalData string) string {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	assert.NilError(t, err)
+	iv := make([]byte, 32)
+	_, err = rand.Read(iv)
+	assert.NilError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	assert.NilError(t, err)
+
+	out := gcm.Seal(nil, iv, []byte(plain), []byte(additionalData))
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(out[:len(out)-16]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(out[len(out)-16:]),
+		typ,
+	)
+}
+
+// encryptSops encrypts a flat document the way sops does, with its data key encrypted for the age recipient. Keys
+// ending in _unencrypted are left as they are
+func encryptSops(t *testing.T, recipient age.Recipient, doc map[string]interface{}) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NilError(t, err)
+
+	// json.Marshal writes the keys sorted, which is the order the MAC is computed in
+	keys := []string{}
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := sha512.New()
+	encrypted := map[string]interface{}{}
+	for _, k := range keys {
+		typ, plain := "str", fmt.Sprint(doc[k])
+		switch v := doc[k].(type) {
+		case int:
+			typ = "int"
+		case bool:
+			typ, plain = "bool", "False"
+			if v {
+				plain = "True"
+			}
+		}
+		mac.Write([]byte(plain))
+
+		if strings.HasSuffix(k, "_unencrypted") {
+			encrypted[k] = doc[k]
+			continue
+		}
+		encrypted[k] = sopsEncryptValue(t, key, plain, typ, k+":")
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encrypted["sops"] = map[string]interface{}{
+		"age":          []map[string]string{{"recipient": fmt.Sprint(recipient), "enc": string(encryptAge(t, recipient, key, true))}},
+		"lastmodified": lastModified,
+		"mac":          sopsEncryptValue(t, key, strings.ToUpper(hex.EncodeToString(mac.Sum(nil))), "str", lastModified),
+	}
+
+	data, err := json.Marshal(encrypted)
+	assert.NilError(t, err)
+
+	return data
+}
+
+func TestDecryptSops(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NilError(t, err)
+	identities := []age.Identity{identity}
+	data := encryptSops(t, identity.Recipient(), map[string]interface{}{"api_key": "abc", "region_unencrypted": "eu-west-1"})
+
+	plain, err := decryptSops(data, identities)
+	assert.NilError(t, err)
+	assert.Equal(t, string(plain), "{\n  \"api_key\": \"abc\",\n  \"region_unencrypted\": \"eu-west-1\"\n}")
+
+	tamper := func(fn func(doc map[string]interface{})) []byte {
+		var doc map[string]interface{}
+		assert.NilError(t, json.Unmarshal(data, &doc))
+		fn(doc)
+		out, err := json.Marshal(doc)
+		assert.NilError(t, err)
+		return out
+	}
+
+	_, err = decryptSops(tamper(func(doc map[string]interface{}) { doc["extra"] = "plain" }), identities)
+	assert.Error(t, err, "sops value of extra is not encrypted")
+
+	_, err = decryptSops(tamper(func(doc map[string]interface{}) { doc["region_unencrypted"] = "us-east-1" }), identities)
+	assert.Error(t, err, "sops MAC mismatch, the document was modified after it was encrypted")
+
+	_, err = decryptSops(tamper(func(doc map[string]interface{}) { delete(doc, "api_key") }), identities)
+	assert.Error(t, err, "sops MAC mismatch, the document was modified after it was encrypted")
+
+	_, err = decryptSops(tamper(func(doc map[string]interface{}) { delete(doc["sops"].(map[string]interface{}), "mac") }), identities)
+	assert.Error(t, err, "sops document has no MAC")
+}
+
+func TestDecryptVarFiles(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NilError(t, err)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		filepath.Join(encryptedDir, "00-secrets.auto.tfvars"):   string(encryptAge(t, identity.Recipient(), []byte("token = \"s3cr3t\"\n"), false)),
+		filepath.Join(encryptedDir, "01-armored.auto.tfvars"):   string(encryptAge(t, identity.Recipient(), []byte("password = \"hunter2\"\n"), true)),
+		filepath.Join(encryptedDir, "02-sops.auto.tfvars.json"): string(encryptSops(t, identity.Recipient(), map[string]interface{}{"api_key": "abc", "replicas": 3, "enabled": true})),
+	})
+
+	target := &zen_targets.Target{Name: "infra", Cwd: dir, Env: map[string]string{"SOPS_AGE_KEY": identity.String()}, TaskLogger: nopLogger{}}
+	cleanup, err := decryptVarFiles(target, dir, "")
+	assert.NilError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "00-secrets.auto.tfvars"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "token = \"s3cr3t\"\n")
+
+	data, err = os.ReadFile(filepath.Join(dir, "01-armored.auto.tfvars"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "password = \"hunter2\"\n")
+
+	data, err = os.ReadFile(filepath.Join(dir, "02-sops.auto.tfvars.json"))
+	assert.NilError(t, err)
+	var values map[string]interface{}
+	assert.NilError(t, json.Unmarshal(data, &values))
+	assert.DeepEqual(t, values, map[string]interface{}{"api_key": "abc", "replicas": float64(3), "enabled": true})
+
+	cleanup()
+	_, err = os.Stat(filepath.Join(dir, "00-secrets.auto.tfvars"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	other, err := age.GenerateX25519Identity()
+	assert.NilError(t, err)
+	target.Env["SOPS_AGE_KEY"] = other.String()
+	cleanup, err = decryptVarFiles(target, dir, "")
+	cleanup()
+	assert.ErrorContains(t, err, "decrypting 00-secrets.auto.tfvars")
+
+	// the key only comes from the env of the target
+	t.Setenv("MISSING_AGE_KEY", identity.String())
+	writeFiles(t, dir, map[string]string{"00-secrets.auto.tfvars": "token = \"stale\"\n"})
+	_, err = decryptVarFiles(target, dir, "MISSING_AGE_KEY")
+	assert.ErrorContains(t, err, "MISSING_AGE_KEY is not set")
+
+	// the plaintext a killed run left behind is removed before anything else
+	_, err = os.Stat(filepath.Join(dir, "00-secrets.auto.tfvars"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestDeployEncryptedVarFiles(t *testing.T) {
+	fe := useFakeExecutor(t)
+	identity, err := age.GenerateX25519Identity()
+	assert.NilError(t, err)
+
+	tc := testConfig("dev")
+	tc.Srcs = []string{"*.tf", "*.age"}
+	tc.VarFiles = []string{"{DEPLOY_ENV}.tfvars.age"}
+	tc.Env = map[string]string{"SOPS_AGE_KEY": identity.String()}
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":        "variable \"token\" {}\n",
+		"dev.tfvars.age": string(encryptAge(t, identity.Recipient(), []byte("token = \"s3cr3t\"\n"), true)),
+	})
+
+	// only the encrypted file is part of the build outputs
+	_, err = os.Stat(filepath.Join(root, "dev", encryptedDir, "00-dev.auto.tfvars"))
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(root, "dev", "00-dev.auto.tfvars"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "dev"}))
+	assert.Equal(t, len(fe.calls(t)), 2)
+
+	_, err = os.Stat(filepath.Join(root, "dev", "00-dev.auto.tfvars"))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+// TestDecryptSopsFixtures decrypts documents encrypted by the sops cli (v3.8.1) for testdata/sops/key.txt
+func TestDecryptSopsFixtures(t *testing.T) {
+	key, err := os.ReadFile(filepath.Join("testdata", "sops", "key.txt"))
+	assert.NilError(t, err)
+	identities, err := age.ParseIdentities(bytes.NewReader(key))
+	assert.NilError(t, err)
+
+	for _, name := range []string{"nested", "regex"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "sops", name+".enc.json"))
+		assert.NilError(t, err)
+		plain, err := decryptSops(data, identities)
+		assert.NilError(t, err, name)
+
+		expected, err := os.ReadFile(filepath.Join("testdata", "sops", name+".json"))
+		assert.NilError(t, err)
+		var got, want interface{}
+		assert.NilError(t, json.Unmarshal(plain, &got))
+		assert.NilError(t, json.Unmarshal(expected, &want))
+		assert.DeepEqual(t, got, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "sops", "binary.enc.json"))
+	assert.NilError(t, err)
+	plain, err := decryptSops(data, identities)
+	assert.NilError(t, err)
+	expected, err := os.ReadFile(filepath.Join("testdata", "sops", "binary.tfvars"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(plain), string(expected))
+
+	data, err = os.ReadFile(filepath.Join("testdata", "sops", "nested.enc.json"))
+	assert.NilError(t, err)
+	_, err = decryptSops(bytes.Replace(data, []byte(`"eu-west-1"`), []byte(`"us-east-1"`), 1), identities)
+	assert.Error(t, err, "sops MAC mismatch, the document was modified after it was encrypted")
+}
@@ -0,0 +1,28 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// envSrcsPrefix names the srcs group holding the env_srcs of an environment
+const envSrcsPrefix = "env_srcs_"
+
+// scopedOverrideRe matches override files scoped to one environment, e.g. waf_override.prod.tf
+var scopedOverrideRe = regexp.MustCompile(`^(.+_override)\.([^.]+)(\.tf(?:\.json)?)$`)
+
+// scopedOverride returns the environment an override file of the srcs is scoped to, and the name terraform
+// recognises it by in that environment. The env is empty for the files that are not scoped overrides, and a
+// scope that is not an environment is an error, terraform would load the file in every environment
+func scopedOverride(name string, scopes map[string]bool) (env, scoped string, err error) {
+	m := scopedOverrideRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", name, nil
+	}
+
+	if !scopes[m[2]] {
+		return "", name, fmt.Errorf("%s is scoped to %s, which is not an environment", name, m[2])
+	}
+
+	return m[2], m[1] + m[3], nil
+}
@@ -0,0 +1,130 @@
+package terraform
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// cancelGracePeriod is how long terraform is given to write its state and release its lock after being interrupted
+var cancelGracePeriod = 2 * time.Minute
+
+// executor creates and runs the commands of the external tools (terraform, tflocal, tflint). Tests swap it
+// for one running a fake terraform
+type executor interface {
+	Command(target *zen_targets.Target, tool string, args []string) *exec.Cmd
+	Run(target *zen_targets.Target, cmd *exec.Cmd) error
+}
+
+type processExecutor struct{}
+
+func (processExecutor) Command(target *zen_targets.Target, tool string, args []string) *exec.Cmd {
+	cmd := exec.Command(target.Tools[tool], args...)
+	cmd.Dir = target.Cwd
+	cmd.Env = target.GetEnvironmentVariablesList()
+	// the tool gets its own process group, so a terminal interrupt reaches it only once, through us.
+	// A second interrupt makes terraform exit immediately, without releasing the state lock
+	setProcessGroup(cmd)
+
+	return cmd
+}
+
+func (processExecutor) Run(target *zen_targets.Target, cmd *exec.Cmd) error {
+	return runInterruptible(target, cmd)
+}
+
+var toolExecutor executor = processExecutor{}
+
+func newToolCmd(target *zen_targets.Target, tool string, args []string) *exec.Cmd {
+	return toolExecutor.Command(target, tool, args)
+}
+
+func newTerraformCmd(target *zen_targets.Target, env string, args []string) *exec.Cmd {
+	return newToolCmd(target, tfExecutable(env), args)
+}
+
+// runInterruptible runs cmd, forwarding an interrupt to it when zen is cancelled. The process is only
+// killed if it does not exit within cancelGracePeriod, or if a second interrupt is received
+func runInterruptible(target *zen_targets.Target, cmd *exec.Cmd) error {
+	target.Debugln(secretsOf(target).redact(strings.Join(cmd.Args, " ")))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", cmd.Path, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var sig os.Signal
+	select {
+	case err := <-done:
+		return err
+	case sig = <-sigs:
+	}
+
+	target.SetStatus(fmt.Sprintf("Interrupted, waiting up to %s for %s to release its locks", cancelGracePeriod, target.Qn()))
+	if err := interruptProcess(cmd.Process); err != nil {
+		cmd.Process.Kill()
+	}
+
+	select {
+	case <-done:
+		return fmt.Errorf("interrupted by %s", sig)
+	case <-sigs:
+	case <-time.After(cancelGracePeriod):
+	}
+
+	cmd.Process.Kill()
+	<-done
+
+	return fmt.Errorf("terraform was killed before it could finish after being interrupted by %s, the state lock may have been left behind. Run the unlock script to release it", sig)
+}
+
+var terraformExec = func(target *zen_targets.Target, env string, args []string) error {
+	var out bytes.Buffer
+	cmd := newTerraformCmd(target, env, args)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := toolExecutor.Run(target, cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("tf exec: %s", secretsOf(target).redact(out.String()))
+		}
+		return fmt.Errorf("tf exec: %w\n%s", err, secretsOf(target).redact(out.String()))
+	}
+
+	return nil
+}
+
+// terraformCombinedOutput captures stderr too, where terraform prints lock info
+var terraformCombinedOutput = func(target *zen_targets.Target, env string, args []string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := newTerraformCmd(target, env, args)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := toolExecutor.Run(target, cmd)
+	return out.Bytes(), err
+}
+
+var terraformOutput = func(target *zen_targets.Target, env string, args []string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := newTerraformCmd(target, env, args)
+	cmd.Stdout = &out
+
+	err := toolExecutor.Run(target, cmd)
+	return out.Bytes(), err
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package terraform
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptProcess forwards the interrupt to the tool, which runs in its own process group
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
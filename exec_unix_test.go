@@ -0,0 +1,68 @@
+//go:build !windows
+
+package terraform
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+// interruptWhenStarted interrupts the test process once the script of the child created the started file
+func interruptWhenStarted(t *testing.T, started string) {
+	t.Helper()
+
+	go func() {
+		for i := 0; i < 200; i++ {
+			if _, err := os.Stat(started); err == nil {
+				syscall.Kill(os.Getpid(), syscall.SIGINT)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func TestRunInterruptible(t *testing.T) {
+	dir := t.TempDir()
+	started, handled := filepath.Join(dir, "started"), filepath.Join(dir, "handled")
+	target := &zen_targets.Target{Name: "infra", Cwd: dir, TaskLogger: nopLogger{}}
+
+	// the child releases its lock on the first interrupt
+	cmd := exec.Command("sh", "-c", "trap 'touch handled; exit 1' INT; touch started; while :; do sleep 0.05; done")
+	cmd.Dir = dir
+	setProcessGroup(cmd)
+	interruptWhenStarted(t, started)
+
+	err := runInterruptible(target, cmd)
+	assert.Error(t, err, "interrupted by interrupt")
+	_, err = os.Stat(handled)
+	assert.NilError(t, err)
+}
+
+func TestRunInterruptibleGracePeriod(t *testing.T) {
+	grace := cancelGracePeriod
+	cancelGracePeriod = 200 * time.Millisecond
+	t.Cleanup(func() { cancelGracePeriod = grace })
+
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	target := &zen_targets.Target{Name: "infra", Cwd: dir, TaskLogger: nopLogger{}}
+
+	// the child ignores the interrupt, it is only killed once the grace period is over
+	cmd := exec.Command("sh", "-c", "trap '' INT; touch started; while :; do sleep 0.05; done")
+	cmd.Dir = dir
+	setProcessGroup(cmd)
+	interruptWhenStarted(t, started)
+
+	start := time.Now()
+	err := runInterruptible(target, cmd)
+	assert.ErrorContains(t, err, "terraform was killed before it could finish after being interrupted by interrupt")
+	assert.Assert(t, time.Since(start) >= cancelGracePeriod)
+}
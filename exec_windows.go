@@ -0,0 +1,15 @@
+//go:build windows
+
+package terraform
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcess does nothing, the console already delivered Ctrl-C to the tool and it cannot be forwarded
+func interruptProcess(p *os.Process) error {
+	return nil
+}
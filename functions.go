@@ -2,62 +2,133 @@ package terraform
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	zen_targets "github.com/zen-io/zen-core/target"
 )
 
-var terraformExec = func(target *zen_targets.Target, env string, args []string) error {
-	var executable string
+func tfExecutable(env string) string {
 	if env == "local" {
-		executable = "tflocal"
-	} else {
-		executable = "terraform"
+		return "tflocal"
 	}
 
-	return target.Exec(append([]string{target.Tools[executable]}, args...), "tf exec")
+	return "terraform"
 }
 
-var tfInit = func(target *zen_targets.Target, env string) error {
-	if err := terraformExec(target, env, []string{"init"}); err != nil {
+var tfInit = func(target *zen_targets.Target, env string, extraArgs ...string) error {
+	if err := terraformExec(target, env, append([]string{"init"}, extraArgs...)); err != nil {
 		return fmt.Errorf("executing init: %w", err)
 	}
 
 	return nil
 }
 
-var tfPlanApply = func(target *zen_targets.Target, env string) error {
-	if err := terraformExec(target, env, []string{"plan"}); err != nil {
+var tfPlanApply = func(target *zen_targets.Target, env string, extraArgs ...string) error {
+	if err := terraformExecJSON(target, env, "Planning", append([]string{"plan"}, extraArgs...)); err != nil {
 		return fmt.Errorf("executing plan: %w", err)
 	}
 
 	return nil
 }
 
-var tfPlanDestroy = func(target *zen_targets.Target, env string) error {
-	if err := terraformExec(target, env, []string{"plan", "-destroy"}); err != nil {
+var tfPlanDestroy = func(target *zen_targets.Target, env string, extraArgs ...string) error {
+	if err := terraformExecJSON(target, env, "Planning", append([]string{"plan", "-destroy"}, extraArgs...)); err != nil {
 		return fmt.Errorf("executing plan: %w", err)
 	}
 
 	return nil
 }
 
-var tfApply = func(target *zen_targets.Target, env string) error {
-	if err := terraformExec(target, env, []string{"apply", "-auto-approve"}); err != nil {
+var tfApply = func(target *zen_targets.Target, env string, extraArgs ...string) error {
+	if err := terraformExecJSON(target, env, "Applying", append([]string{"apply", "-auto-approve"}, extraArgs...)); err != nil {
 		return fmt.Errorf("executing apply: %w", err)
 	}
 
 	return nil
 }
 
-var tfDestroy = func(target *zen_targets.Target, env string) error {
-	if err := terraformExec(target, env, []string{"apply", "-destroy", "-auto-approve"}); err != nil {
+// tfApplyPlan applies a saved plan, which needs no approval
+var tfApplyPlan = func(target *zen_targets.Target, env string, plan string, extraArgs ...string) error {
+	if err := terraformExecJSON(target, env, "Applying", append(append([]string{"apply"}, extraArgs...), "-json", plan)); err != nil {
+		return fmt.Errorf("executing apply: %w", err)
+	}
+
+	return nil
+}
+
+var tfDestroy = func(target *zen_targets.Target, env string, extraArgs ...string) error {
+	if err := terraformExecJSON(target, env, "Destroying", append([]string{"apply", "-destroy", "-auto-approve"}, extraArgs...)); err != nil {
 		return fmt.Errorf("executing destroy: %w", err)
 	}
 
 	return nil
 }
 
+var tfStateList = func(target *zen_targets.Target, env string) ([]string, error) {
+	out, err := terraformOutput(target, env, []string{"state", "list"})
+	if err != nil {
+		return nil, fmt.Errorf("executing state list: %w", err)
+	}
+
+	return strings.Fields(string(out)), nil
+}
+
+var tfStateMv = func(target *zen_targets.Target, env string, from, to string, dryRun bool) error {
+	args := []string{"state", "mv"}
+	if dryRun {
+		args = append(args, "-dry-run")
+	}
+
+	if err := terraformExec(target, env, append(args, from, to)); err != nil {
+		return fmt.Errorf("executing state mv: %w", err)
+	}
+
+	return nil
+}
+
+var tfStateRm = func(target *zen_targets.Target, env string, address string, dryRun bool) error {
+	args := []string{"state", "rm"}
+	if dryRun {
+		args = append(args, "-dry-run")
+	}
+
+	if err := terraformExec(target, env, append(args, address)); err != nil {
+		return fmt.Errorf("executing state rm: %w", err)
+	}
+
+	return nil
+}
+
+func targetingArgs(targets, replace []string) []string {
+	args := []string{}
+	for _, t := range targets {
+		args = append(args, "-target="+t)
+	}
+	for _, r := range replace {
+		args = append(args, "-replace="+r)
+	}
+
+	return args
+}
+
+// envList reads a comma separated list from the OS environment
+func envList(name string) []string {
+	return splitList(os.Getenv(name))
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
 var preFunc = func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
 	target.Cwd = filepath.Join(target.Cwd, runCtx.Env)
 	return nil
@@ -3,21 +3,30 @@ module github.com/zen-io/zen-target-terraform
 go 1.20
 
 require (
+	filippo.io/age v1.0.0
+	github.com/hashicorp/hcl/v2 v2.17.0
+	github.com/tiagoposse/go-tasklist-out v0.0.0-20230612172535-e54b6ceb9584
+	github.com/zclconf/go-cty v1.13.2
 	github.com/zen-io/zen-core v0.0.0-20230715105113-826c445b50a1
 	golang.org/x/exp v0.0.0-20230713183714-613f0c0eb8a1
+	gopkg.in/yaml.v3 v3.0.1
+	gotest.tools/v3 v3.5.0
 )
 
 require (
 	atomicgo.dev/cursor v0.1.2 // indirect
+	github.com/agext/levenshtein v1.2.1 // indirect
+	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
 	github.com/bmatcuk/doublestar/v4 v4.6.0 // indirect
 	github.com/fatih/color v1.15.0 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tiagoposse/go-sync-types v0.0.0-20230606060517-e7839c4bca50 // indirect
-	github.com/tiagoposse/go-tasklist-out v0.0.0-20230612172535-e54b6ceb9584 // indirect
 	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/term v0.10.0 // indirect
-	gotest.tools/v3 v3.5.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 )
@@ -0,0 +1,226 @@
+package terraform
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	out_mgr "github.com/tiagoposse/go-tasklist-out"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+// fakeTerraform is the binary built from testdata/faketf, run in place of every tool
+var fakeTerraform string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "faketf")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fakeTerraform = filepath.Join(dir, "faketf")
+	if out, err := exec.Command("go", "build", "-o", fakeTerraform, "./testdata/faketf").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building fake terraform: %s\n%s", err, out)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeExecutor runs every tool through the fake terraform, which records its calls to log and replies with
+// the responses written by respond. The commands are run the way the real ones are
+type fakeExecutor struct {
+	processExecutor
+	log       string
+	responses string
+}
+
+func (fe *fakeExecutor) Command(target *zen_targets.Target, tool string, args []string) *exec.Cmd {
+	cmd := exec.Command(fakeTerraform, args...)
+	cmd.Dir = target.Cwd
+	cmd.Env = append(os.Environ(), "FAKETF_TOOL="+tool, "FAKETF_LOG="+fe.log, "FAKETF_RESPONSES="+fe.responses)
+	setProcessGroup(cmd)
+
+	return cmd
+}
+
+// respond makes the fake reply to command (e.g. "state list") with stdout, exiting with code
+func (fe *fakeExecutor) respond(t *testing.T, command, stdout string, code int) {
+	t.Helper()
+
+	name := filepath.Join(fe.responses, strings.ReplaceAll(command, " ", "_"))
+	assert.NilError(t, os.WriteFile(name+".stdout", []byte(stdout), 0644))
+	assert.NilError(t, os.WriteFile(name+".exit", []byte(fmt.Sprint(code)), 0644))
+}
+
+// respondStderr makes the fake reply to command with stderr, exiting with code
+func (fe *fakeExecutor) respondStderr(t *testing.T, command, stderr string, code int) {
+	t.Helper()
+
+	name := filepath.Join(fe.responses, strings.ReplaceAll(command, " ", "_"))
+	assert.NilError(t, os.WriteFile(name+".stderr", []byte(stderr), 0644))
+	assert.NilError(t, os.WriteFile(name+".exit", []byte(fmt.Sprint(code)), 0644))
+}
+
+type fakeCall struct {
+	Tool string   `json:"tool"`
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+}
+
+func (fc fakeCall) String() string {
+	return strings.Join(append([]string{fc.Tool}, fc.Args...), " ")
+}
+
+// calls returns the recorded calls, in order
+func (fe *fakeExecutor) calls(t *testing.T) []fakeCall {
+	t.Helper()
+
+	f, err := os.Open(fe.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	assert.NilError(t, err)
+	defer f.Close()
+
+	calls := []fakeCall{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fc fakeCall
+		assert.NilError(t, json.Unmarshal(scanner.Bytes(), &fc))
+		calls = append(calls, fc)
+	}
+
+	return calls
+}
+
+// commands returns the recorded calls as command lines
+func (fe *fakeExecutor) commands(t *testing.T) []string {
+	t.Helper()
+
+	commands := []string{}
+	for _, fc := range fe.calls(t) {
+		commands = append(commands, fc.String())
+	}
+
+	return commands
+}
+
+// useFakeExecutor swaps the tool executor for a fake one for the duration of the test
+func useFakeExecutor(t *testing.T) *fakeExecutor {
+	dir := t.TempDir()
+	fe := &fakeExecutor{log: filepath.Join(dir, "calls.jsonl"), responses: filepath.Join(dir, "responses")}
+	assert.NilError(t, os.Mkdir(fe.responses, os.ModePerm))
+
+	prev := toolExecutor
+	toolExecutor = fe
+	t.Cleanup(func() { toolExecutor = prev })
+
+	return fe
+}
+
+// writeFiles creates the files, relative to root, with their contents
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func getTarget(t *testing.T, tc TerraformConfig) *zen_targets.TargetBuilder {
+	t.Helper()
+
+	tcc := &zen_targets.TargetConfigContext{
+		KnownToolchains: map[string]string{"terraform": "terraform", "tflocal": "tflocal", "tflint": "tflint"},
+	}
+
+	tbs, err := tc.GetTargets(tcc)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tbs), 1)
+
+	return tbs[0]
+}
+
+// nopLogger stands in for the task logger zen gives every target, discarding the statuses and logs
+type nopLogger struct {
+	out_mgr.TaskLogger
+}
+
+func (nopLogger) SetStatus(s string, args ...interface{}) {}
+func (nopLogger) Debugln(s string, args ...interface{})   {}
+func (nopLogger) Infoln(s string, args ...interface{})    {}
+func (nopLogger) Warnln(s string, args ...interface{})    {}
+func (nopLogger) Errorln(s string, args ...interface{})   {}
+
+// newTestTarget creates the target zen would run the builder scripts with, root being its sandbox
+func newTestTarget(t *testing.T, tb *zen_targets.TargetBuilder, root string) *zen_targets.Target {
+	t.Helper()
+
+	srcs := map[string][]string{}
+	for key, patterns := range tb.Srcs {
+		srcs[key] = []string{}
+		for _, pattern := range patterns {
+			if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+				err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+					if err == nil && !d.IsDir() {
+						srcs[key] = append(srcs[key], path)
+					}
+					return err
+				})
+				assert.NilError(t, err)
+				continue
+			}
+
+			matches, err := filepath.Glob(filepath.Join(root, pattern))
+			assert.NilError(t, err)
+			srcs[key] = append(srcs[key], matches...)
+		}
+	}
+
+	return &zen_targets.Target{
+		Name:          tb.Name,
+		Srcs:          srcs,
+		Labels:        tb.Labels,
+		Tools:         tb.Tools,
+		Env:           tb.Env,
+		PassEnv:       tb.PassEnv,
+		PassSecretEnv: tb.PassSecretEnv,
+		Environments:  tb.Environments,
+		TaskLogger:    nopLogger{},
+		Cwd:           root,
+	}
+}
+
+// runScript runs a script of the builder the way zen does, pre, run and post
+func runScript(t *testing.T, tb *zen_targets.TargetBuilder, root, name string, runCtx *zen_targets.RuntimeContext) error {
+	t.Helper()
+
+	script, ok := tb.Scripts[name]
+	assert.Assert(t, ok, "no %s script", name)
+
+	target := newTestTarget(t, tb, root)
+	for _, fn := range []func(*zen_targets.Target, *zen_targets.RuntimeContext) error{script.Pre, script.Run, script.Post} {
+		if fn == nil {
+			continue
+		}
+
+		if err := fn(target, runCtx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+)
+
+const (
+	// interpolationZen replaces {VAR} anywhere in the file, which also matches terraform's own ${...} expressions
+	interpolationZen = "zen"
+	// interpolationHCL only replaces ${zen.VAR} placeholders in terraform strings, escaping the values
+	interpolationHCL = "hcl"
+)
+
+func validInterpolation(mode string) error {
+	switch mode {
+	case "", interpolationZen, interpolationHCL:
+		return nil
+	default:
+		return fmt.Errorf("unknown interpolation %s, must be %s or %s", mode, interpolationZen, interpolationHCL)
+	}
+}
+
+// placeholderRe matches the hcl mode placeholders in files that are not lexed as HCL. An extra $ escapes them
+var placeholderRe = regexp.MustCompile(`\$?\$\{zen\.([A-Za-z0-9_\-]+)\}`)
+
+// zenPlaceholderRe matches the zen mode {VAR} placeholders. Unlike zen-core it also matches names with digits and
+// dashes, so that they are reported when unknown instead of being left in place
+var zenPlaceholderRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_.\-]*)\}`)
+
+// maxInterpolationDepth bounds the passes over values that have placeholders themselves
+const maxInterpolationDepth = 10
+
+// interpolateZen replaces the {VAR} placeholders of text, failing on the ones not in vars
+func interpolateZen(text string, vars map[string]string) (string, error) {
+	for i := 0; i < maxInterpolationDepth; i++ {
+		unknown := []string{}
+		replaced := zenPlaceholderRe.ReplaceAllStringFunc(text, func(m string) string {
+			val, ok := vars[m[1:len(m)-1]]
+			if !ok {
+				unknown = append(unknown, m)
+				return m
+			}
+			return val
+		})
+
+		if len(unknown) > 0 {
+			return "", fmt.Errorf("unknown placeholders %s", strings.Join(unknown, ", "))
+		} else if replaced == text {
+			return text, nil
+		}
+		text = replaced
+	}
+
+	return "", fmt.Errorf("placeholders nested more than %d levels deep", maxInterpolationDepth)
+}
+
+// copyInterpolated copies a file into the build directory, interpolating it with the given mode
+func copyInterpolated(target *zen_targets.Target, mode, from, to string, vars map[string]string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return fmt.Errorf("reading from %s: %w", from, err)
+	}
+
+	if utils.CheckFileCanInterpolate(data) {
+		if mode == interpolationHCL {
+			data, err = interpolateHCL(data, filepath.Base(from), vars)
+		} else {
+			var interpolated string
+			interpolated, err = interpolateZen(string(data), vars)
+			data = []byte(interpolated)
+		}
+		if err != nil {
+			return fmt.Errorf("interpolating %s: %w", target.StripCwd(from), err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(to), err)
+	}
+
+	if err := os.WriteFile(to, data, 0644); err != nil {
+		return fmt.Errorf("writing to %s: %w", to, err)
+	}
+
+	return nil
+}
+
+func isHCLFile(name string) bool {
+	for _, ext := range []string{".tf", ".hcl", ".tfvars"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interpolateHCL replaces the ${zen.VAR} placeholders of a file. In HCL files only placeholders that are a whole
+// template interpolation are replaced, and the values are escaped for the quoted string or heredoc they land in.
+// Terraform's escaped $${zen.VAR} is left untouched
+func interpolateHCL(src []byte, filename string, vars map[string]string) ([]byte, error) {
+	if !isHCLFile(filename) {
+		jsonFile := strings.HasSuffix(filename, ".json")
+
+		var err error
+		out := placeholderRe.ReplaceAllStringFunc(string(src), func(m string) string {
+			if strings.HasPrefix(m, "$$") {
+				return m
+			}
+
+			name := placeholderRe.FindStringSubmatch(m)[1]
+			val, ok := vars[name]
+			if !ok {
+				err = fmt.Errorf("unknown placeholder %s", m)
+				return m
+			}
+
+			if jsonFile {
+				return escapeJSONTemplate(val)
+			}
+			return val
+		})
+
+		return []byte(out), err
+	}
+
+	tokens, diags := hclsyntax.LexConfig(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var out bytes.Buffer
+	last := 0
+	// the opening tokens of the strings we are in, interpolations can nest strings
+	strs := []hclsyntax.TokenType{}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Type {
+		case hclsyntax.TokenOQuote, hclsyntax.TokenOHeredoc:
+			strs = append(strs, tok.Type)
+		case hclsyntax.TokenCQuote, hclsyntax.TokenCHeredoc:
+			if len(strs) > 0 {
+				strs = strs[:len(strs)-1]
+			}
+		case hclsyntax.TokenTemplateInterp:
+			if i+4 >= len(tokens) ||
+				tokens[i+1].Type != hclsyntax.TokenIdent || string(tokens[i+1].Bytes) != "zen" ||
+				tokens[i+2].Type != hclsyntax.TokenDot ||
+				tokens[i+3].Type != hclsyntax.TokenIdent ||
+				tokens[i+4].Type != hclsyntax.TokenTemplateSeqEnd {
+				continue
+			}
+
+			name := string(tokens[i+3].Bytes)
+			val, ok := vars[name]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unknown placeholder ${zen.%s}", filename, tok.Range.Start.Line, name)
+			}
+
+			quoted := len(strs) > 0 && strs[len(strs)-1] == hclsyntax.TokenOQuote
+			out.Write(src[last:tok.Range.Start.Byte])
+			out.WriteString(escapeHCLTemplate(val, quoted))
+			last = tokens[i+4].Range.End.Byte
+			i += 4
+		}
+	}
+	out.Write(src[last:])
+
+	return out.Bytes(), nil
+}
+
+var templateEscaper = strings.NewReplacer("${", "$${", "%{", "%%{")
+
+// escapeHCLTemplate escapes a value so terraform reads it back literally. Heredocs only need the template
+// sequences escaped, quoted strings also the quotes, backslashes and line breaks
+func escapeHCLTemplate(val string, quoted bool) string {
+	if quoted {
+		val = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(val)
+	}
+
+	return templateEscaper.Replace(val)
+}
+
+// escapeJSONTemplate escapes a value for a JSON string, which terraform also reads as a template
+func escapeJSONTemplate(val string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(val)
+
+	encoded := strings.TrimSuffix(buf.String(), "\n")
+	return templateEscaper.Replace(encoded[1 : len(encoded)-1])
+}
+
+// gitRevision returns the commit the package is built from
+var gitRevision = func(target *zen_targets.Target) (string, error) {
+	out, err := exec.Command("git", "-C", packageDir(target), "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("resolving git revision: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// interpolationContext returns what the build interpolates an env with: the target and environment variables, and
+// DEPLOY_ENV, TARGET_NAME, TARGET_PACKAGE, GIT_REVISION (when in a repository) and BACKEND_KEY. The backend key
+// defaults to <package>/<name>/<env>/terraform.tfstate, and can use all the others
+func interpolationContext(target *zen_targets.Target, env string, envVariables map[string]string, backendKey, revision string) (map[string]string, error) {
+	builtins := map[string]string{
+		"TARGET_NAME":    target.Name,
+		"TARGET_PACKAGE": target.Package(),
+	}
+	if env != "" {
+		builtins["DEPLOY_ENV"] = env
+	}
+	if revision != "" {
+		builtins["GIT_REVISION"] = revision
+	}
+
+	vars := utils.MergeMaps(target.EnvVars(), envVariables, builtins)
+
+	if backendKey == "" {
+		vars["BACKEND_KEY"] = path.Join(target.Package(), target.Name, env, "terraform.tfstate")
+	} else {
+		key, err := interpolateZen(backendKey, vars)
+		if err != nil {
+			return nil, fmt.Errorf("interpolating backend key: %w", err)
+		}
+		vars["BACKEND_KEY"] = key
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,94 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestInterpolateHCL(t *testing.T) {
+	vars := map[string]string{"DEPLOY_ENV": "prod", "QUOTED": `a "b" ${c}`}
+
+	for _, tt := range []struct {
+		name, filename, src, expected string
+	}{
+		{
+			name:     "terraform expressions untouched",
+			filename: "main.tf",
+			src:      "bucket = \"${var.prefix}-${zen.DEPLOY_ENV}\"\nname = \"$${zen.DEPLOY_ENV}\"\nkey = {DEPLOY_ENV}\n",
+			expected: "bucket = \"${var.prefix}-prod\"\nname = \"$${zen.DEPLOY_ENV}\"\nkey = {DEPLOY_ENV}\n",
+		},
+		{
+			name:     "quoted values escaped",
+			filename: "main.tf",
+			src:      "a = \"${zen.QUOTED}\"\nb = \"${lookup(x, \"${zen.DEPLOY_ENV}\")}\"\n",
+			expected: "a = \"a \\\"b\\\" $${c}\"\nb = \"${lookup(x, \"prod\")}\"\n",
+		},
+		{
+			name:     "heredoc",
+			filename: "main.tf",
+			src:      "a = <<EOT\n${zen.QUOTED}\nEOT\n",
+			expected: "a = <<EOT\na \"b\" $${c}\nEOT\n",
+		},
+		{
+			name:     "json",
+			filename: "main.tf.json",
+			src:      `{"a": "${zen.QUOTED}", "b": "$${zen.QUOTED}"}`,
+			expected: `{"a": "a \"b\" $${c}", "b": "$${zen.QUOTED}"}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := interpolateHCL([]byte(tt.src), tt.filename, vars)
+			assert.NilError(t, err)
+			assert.Equal(t, string(out), tt.expected)
+		})
+	}
+
+	_, err := interpolateHCL([]byte("a = \"${zen.MISSING}\"\n"), "main.tf", vars)
+	assert.ErrorContains(t, err, "main.tf:1: unknown placeholder ${zen.MISSING}")
+}
+
+func TestBuildInterpolateSrcs(t *testing.T) {
+	tc := testConfig("prod")
+	tc.Interpolation = interpolationHCL
+	tc.InterpolateSrcs = true
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{"main.tf": "locals {\n  name = \"${var.prefix}-${zen.DEPLOY_ENV}\"\n}\n"})
+
+	data, err := os.ReadFile(filepath.Join(root, "prod", "main.tf"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "locals {\n  name = \"${var.prefix}-prod\"\n}\n")
+
+	tc.Interpolation = "jinja"
+	_, err = tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "unknown interpolation jinja")
+}
+
+func TestBuildInterpolationContext(t *testing.T) {
+	revision := gitRevision
+	gitRevision = func(target *zen_targets.Target) (string, error) { return "abc123", nil }
+	t.Cleanup(func() { gitRevision = revision })
+
+	backend := "backend.tf"
+	tc := testConfig("prod")
+	tc.Backend = &backend
+	tc.BackendKey = "states/{TARGET_NAME}/{DEPLOY_ENV}.tfstate"
+	tc.Environments["prod"].Variables["REGION_1"] = "eu-west-1"
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":    "",
+		"backend.tf": "key = \"{BACKEND_KEY}\"\nregion = \"{REGION_1}\"\nrevision = \"{GIT_REVISION}\"\n",
+	})
+
+	data, err := os.ReadFile(filepath.Join(root, "prod", "_backend_backend.tf"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "key = \"states/infra/prod.tfstate\"\nregion = \"eu-west-1\"\nrevision = \"abc123\"\n")
+
+	root = t.TempDir()
+	writeFiles(t, root, map[string]string{"main.tf": "", "backend.tf": "region = \"{REGION_2}\"\n"})
+	err = runScript(t, tb, root, "build", &zen_targets.RuntimeContext{})
+	assert.ErrorContains(t, err, "env prod: copying backend: interpolating backend.tf: unknown placeholders {REGION_2}")
+}
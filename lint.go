@@ -0,0 +1,175 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// tflintConfigFile is where build places the configured tflint config, in every env directory
+const tflintConfigFile = ".tflint.hcl"
+
+type tflintRange struct {
+	Filename string `json:"filename"`
+	Start    uiPos  `json:"start"`
+}
+
+type tflintIssue struct {
+	Rule struct {
+		Name     string `json:"name"`
+		Severity string `json:"severity"`
+	} `json:"rule"`
+	Message string       `json:"message"`
+	Range   *tflintRange `json:"range"`
+}
+
+type tflintError struct {
+	Message  string       `json:"message"`
+	Severity string       `json:"severity"`
+	Range    *tflintRange `json:"range"`
+}
+
+// tflintOutput is the result of tflint --format json
+type tflintOutput struct {
+	Issues []tflintIssue `json:"issues"`
+	Errors []tflintError `json:"errors"`
+}
+
+var tflintExec = func(target *zen_targets.Target, pluginDir string, args []string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := newToolCmd(target, "tflint", args)
+	if pluginDir != "" {
+		cmd.Env = append(cmd.Env, "TFLINT_PLUGIN_DIR="+pluginDir)
+	}
+	cmd.Stdout = &out
+
+	err := toolExecutor.Run(target, cmd)
+	return out.Bytes(), err
+}
+
+// tflintSeverity maps tflint severities to the report levels
+func tflintSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "error":
+		return "error"
+	case "warning":
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func (to *tflintOutput) findings(sm sourceMap) []finding {
+	findings := []finding{}
+	for _, issue := range to.Issues {
+		f := finding{RuleID: issue.Rule.Name, Level: tflintSeverity(issue.Rule.Severity), Message: issue.Message}
+		if issue.Range != nil {
+			f.File = sm.resolve(issue.Range.Filename)
+			f.Line = issue.Range.Start.Line
+			f.Column = issue.Range.Start.Column
+		}
+		findings = append(findings, f)
+	}
+
+	for _, e := range to.Errors {
+		f := finding{RuleID: "tflint", Level: tflintSeverity(e.Severity), Message: e.Message}
+		if e.Range != nil {
+			f.File = sm.resolve(e.Range.Filename)
+			f.Line = e.Range.Start.Line
+			f.Column = e.Range.Start.Column
+		}
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+// envVarFiles returns the var files build placed in the env directory, in the order terraform loads them
+func envVarFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".auto.tfvars") || strings.HasSuffix(entry.Name(), ".auto.tfvars.json") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func runLint(pluginDirs func(env string) string) func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	return func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+		pluginDir := pluginDirs(runCtx.Env)
+		if _, ok := target.Tools["tflint"]; !ok {
+			return fmt.Errorf("tflint is not configured")
+		}
+
+		args := []string{}
+		if _, err := os.Stat(filepath.Join(target.Cwd, tflintConfigFile)); err == nil {
+			args = append(args, "--config", tflintConfigFile)
+		}
+
+		target.SetStatus(fmt.Sprintf("Initializing tflint for %s", target.Qn()))
+		if out, err := tflintExec(target, pluginDir, append([]string{"--init"}, args...)); err != nil {
+			return fmt.Errorf("initializing tflint: %w: %s", err, secretsOf(target).redact(string(out)))
+		}
+
+		varFiles, err := envVarFiles(target.Cwd)
+		if err != nil {
+			return err
+		}
+		for _, vf := range varFiles {
+			args = append(args, "--var-file", vf)
+		}
+
+		target.SetStatus(fmt.Sprintf("Linting %s", target.Qn()))
+		out, execErr := tflintExec(target, pluginDir, append(args, "--format", "json"))
+
+		var to tflintOutput
+		if err := json.Unmarshal(out, &to); err != nil {
+			if execErr != nil {
+				return fmt.Errorf("tf lint: %w", execErr)
+			}
+			return fmt.Errorf("decoding tflint output: %w", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(target.Cwd, "lint.json"), []byte(secretsOf(target).redact(string(out))), 0644); err != nil {
+			return fmt.Errorf("writing lint output: %w", err)
+		}
+
+		findings := to.findings(loadSourceMap(target.Cwd))
+		if err := writeReports(target, "lint", "tflint", findings); err != nil {
+			return err
+		}
+
+		target.SetStatus(fmt.Sprintf("Scanning %s for secrets", target.Qn()))
+		secretFindings, err := scanSecrets(target, packageRoot(target, runCtx))
+		if err != nil {
+			return err
+		}
+		if err := writeReports(target, "secrets", "zen secrets", secretFindings); err != nil {
+			return err
+		}
+
+		if errs := countErrors(findings); errs > 0 {
+			return findingsError(findings, "tflint found %d errors", errs)
+		}
+
+		if errs := countErrors(secretFindings); errs > 0 {
+			return findingsError(secretFindings, "found %d possible secrets", errs)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,168 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	environs "github.com/zen-io/zen-core/environments"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"github.com/zen-io/zen-core/utils"
+)
+
+// baseEnvVariable holds the environment a matrix combination was expanded from
+const baseEnvVariable = "BASE_ENV"
+
+var (
+	matrixAxisRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	// axis values are part of the environment directories, they cannot have separators or start with a dot
+	matrixValueRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]*$`)
+)
+
+// mergeEnvironment merges environments, later ones taking precedence. Unlike zen-core it keeps the aws and
+// kubernetes configs unset when none of the environments has them
+func mergeEnvironment(envs ...*environs.Environment) *environs.Environment {
+	merged := &environs.Environment{Variables: map[string]string{}}
+	for _, e := range envs {
+		if e == nil {
+			continue
+		}
+
+		if e.Aws != nil {
+			if merged.Aws == nil {
+				merged.Aws = &environs.AwsAuthenticationConfig{}
+			}
+			merged.Aws.Merge(e.Aws)
+		}
+		if e.Kubernetes != nil {
+			if merged.Kubernetes == nil {
+				merged.Kubernetes = &environs.K8sAuthenticationConfig{}
+			}
+			merged.Kubernetes.Merge(e.Kubernetes)
+		}
+		merged.Variables = utils.MergeMaps(merged.Variables, e.Variables)
+	}
+
+	return merged
+}
+
+// matrixCombinations returns every combination of the axis values, the axes in name order
+func matrixCombinations(matrix map[string][]string) (axes []string, combinations [][]string) {
+	for axis := range matrix {
+		axes = append(axes, axis)
+	}
+	sort.Strings(axes)
+
+	combinations = [][]string{{}}
+	for _, axis := range axes {
+		next := [][]string{}
+		for _, combination := range combinations {
+			for _, value := range matrix[axis] {
+				next = append(next, append(append([]string{}, combination...), value))
+			}
+		}
+		combinations = next
+	}
+
+	return axes, combinations
+}
+
+// expandMatrix replaces every environment with one per combination of the matrix axes, named after the environment
+// and the axis values, e.g. prod-eu-west-1. A combination has the variables of its environment, merged with the
+// project ones since zen does not know it, BASE_ENV and every axis value as the upper cased axis name. The env_srcs
+// and env_overrides of an environment apply to all its combinations, merged with the ones of the combination itself.
+// It returns the environment every combination was expanded from
+func (tc *TerraformConfig) expandMatrix(tcc *zen_targets.TargetConfigContext) (map[string]string, error) {
+	if len(tc.Matrix) == 0 {
+		return nil, nil
+	}
+
+	if len(tc.Environments) == 0 {
+		return nil, fmt.Errorf("matrix: needs environments to expand")
+	}
+	for axis, values := range tc.Matrix {
+		if !matrixAxisRe.MatchString(axis) {
+			return nil, fmt.Errorf("matrix: invalid axis %s, must be letters, digits and underscores", axis)
+		} else if len(values) == 0 {
+			return nil, fmt.Errorf("matrix: axis %s has no values", axis)
+		}
+
+		for _, value := range values {
+			if !matrixValueRe.MatchString(value) {
+				return nil, fmt.Errorf("matrix: invalid value %s of axis %s, must be letters, digits, dots, dashes and underscores", value, axis)
+			}
+		}
+	}
+
+	var projectEnvs map[string]*environs.Environment
+	if tcc != nil {
+		projectEnvs = tcc.Environments
+	}
+
+	axes, combinations := matrixCombinations(tc.Matrix)
+	baseEnvs := map[string]string{}
+	environments := map[string]*environs.Environment{}
+	for env, envConf := range tc.Environments {
+		for _, combination := range combinations {
+			name := strings.Join(append([]string{env}, combination...), "-")
+			if _, ok := environments[name]; ok {
+				return nil, fmt.Errorf("matrix: environment %s is expanded more than once", name)
+			}
+
+			axisVars := map[string]string{baseEnvVariable: env}
+			for i, axis := range axes {
+				axisVars[strings.ToUpper(axis)] = combination[i]
+			}
+
+			environments[name] = mergeEnvironment(projectEnvs[env], envConf, &environs.Environment{Variables: axisVars})
+			baseEnvs[name] = env
+		}
+	}
+
+	// the settings of the base environments move to their combinations
+	envSrcs := map[string][]string{}
+	envOverrides := map[string]*EnvOverride{}
+	for env, srcs := range tc.EnvSrcs {
+		if _, ok := tc.Environments[env]; !ok {
+			envSrcs[env] = srcs
+		}
+	}
+	for env, o := range tc.EnvOverrides {
+		if _, ok := tc.Environments[env]; !ok {
+			envOverrides[env] = o
+		}
+	}
+
+	for name, env := range baseEnvs {
+		if srcs := extendList(tc.EnvSrcs[env], tc.EnvSrcs[name]); len(srcs) > 0 {
+			envSrcs[name] = srcs
+		}
+
+		var merged *EnvOverride
+		for _, o := range []*EnvOverride{tc.EnvOverrides[env], tc.EnvOverrides[name]} {
+			if o == nil {
+				continue
+			} else if merged == nil {
+				merged = &EnvOverride{}
+			}
+
+			merged.TerraformDeploymentConfig.Merge(&o.TerraformDeploymentConfig)
+			if o.Deploy != nil {
+				if merged.Deploy == nil {
+					merged.Deploy = &DeployConfig{}
+				}
+				merged.Deploy.Merge(o.Deploy)
+			}
+		}
+		if merged != nil {
+			envOverrides[name] = merged
+		}
+	}
+
+	tc.Environments = environments
+	tc.EnvSrcs = envSrcs
+	tc.EnvOverrides = envOverrides
+
+	return baseEnvs, nil
+}
@@ -0,0 +1,98 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestMatrix(t *testing.T) {
+	fe := useFakeExecutor(t)
+	backend := "backend.tf"
+	tc := testConfig("dev", "prod")
+	tc.Environments["prod"].Variables["TERRAFORM_PROTECTED"] = "true"
+	tc.Backend = &backend
+	tc.Matrix = map[string][]string{"region": {"eu-west-1", "us-east-1"}}
+	tc.EnvSrcs = map[string][]string{"prod": {"overlays/prod/*.tf"}}
+	tb := getTarget(t, tc)
+
+	envs := []string{}
+	for env := range tb.Environments {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	assert.DeepEqual(t, envs, []string{"dev-eu-west-1", "dev-us-east-1", "prod-eu-west-1", "prod-us-east-1"})
+	assert.DeepEqual(t, tb.Environments["prod-us-east-1"].Variables, map[string]string{
+		"TERRAFORM_PROTECTED": "true",
+		"BASE_ENV":            "prod",
+		"REGION":              "us-east-1",
+	})
+
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":              "",
+		"backend.tf":           "key = \"{BACKEND_KEY}\"\nregion = \"{REGION}\"\n",
+		"waf_override.prod.tf": "# prod\n",
+		"overlays/prod/waf.tf": "# waf\n",
+	})
+
+	data, err := os.ReadFile(filepath.Join(root, "prod-us-east-1", "_backend_backend.tf"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "key = \"infra/prod-us-east-1/terraform.tfstate\"\nregion = \"us-east-1\"\n")
+
+	for env, expected := range map[string]bool{"dev-eu-west-1": false, "prod-eu-west-1": true, "prod-us-east-1": true} {
+		for _, file := range []string{"waf.tf", "waf_override.tf"} {
+			_, err := os.Stat(filepath.Join(root, env, file))
+			assert.Equal(t, err == nil, expected, "%s/%s", env, file)
+		}
+	}
+
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "prod-us-east-1"}))
+	for _, fc := range fe.calls(t) {
+		assert.Equal(t, fc.Dir, filepath.Join(root, "prod-us-east-1"))
+	}
+}
+
+func TestMatrixInvalid(t *testing.T) {
+	tc := testConfig()
+	tc.Matrix = map[string][]string{"region": {"eu-west-1"}}
+	_, err := tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "matrix: needs environments to expand")
+
+	tc = testConfig("dev")
+	tc.Matrix = map[string][]string{"region": {}}
+	_, err = tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "matrix: axis region has no values")
+
+	// values become part of the environment directories
+	for _, value := range []string{"../x", "a/b", ".hidden"} {
+		tc = testConfig("dev")
+		tc.Matrix = map[string][]string{"region": {"eu-west-1", value}}
+		_, err = tc.GetTargets(nil)
+		assert.ErrorContains(t, err, "matrix: invalid value "+value+" of axis region")
+	}
+}
+
+func TestMatrixSameBackendKey(t *testing.T) {
+	backend := "backend.tf"
+	tc := testConfig("dev")
+	tc.Backend = &backend
+	tc.BackendKey = "{BASE_ENV}/terraform.tfstate"
+	tc.Matrix = map[string][]string{"region": {"eu-west-1", "us-east-1"}}
+	tb := getTarget(t, tc)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.tf": "", "backend.tf": "key = \"{BACKEND_KEY}\"\n"})
+	err := runScript(t, tb, root, "build", &zen_targets.RuntimeContext{})
+	assert.Error(t, err, "environments dev-eu-west-1 and dev-us-east-1 have the same BACKEND_KEY dev/terraform.tfstate")
+
+	tc = testConfig("dev")
+	tc.Backend = &backend
+	tc.BackendKey = "{BASE_ENV}/{REGION}/terraform.tfstate"
+	tc.Matrix = map[string][]string{"region": {"eu-west-1", "us-east-1"}}
+	tb = getTarget(t, tc)
+	assert.NilError(t, runScript(t, tb, root, "build", &zen_targets.RuntimeContext{}))
+}
@@ -0,0 +1,212 @@
+package terraform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+type CommandOptions struct {
+	Parallelism *int              `mapstructure:"parallelism" desc:"Limit the number of concurrent operations (-parallelism)"`
+	LockTimeout *string           `mapstructure:"lock_timeout" desc:"Duration to retry a state lock (-lock-timeout)"`
+	Refresh     *bool             `mapstructure:"refresh" desc:"Whether to refresh the state before planning (-refresh)"`
+	Upgrade     *bool             `mapstructure:"upgrade" desc:"Upgrade modules and providers on init (-upgrade)"`
+	Reconfigure *bool             `mapstructure:"reconfigure" desc:"Reconfigure the backend on init (-reconfigure)"`
+	Vars        map[string]string `mapstructure:"vars" desc:"Key-Value map of variables to pass with -var"`
+	ExtraArgs   []string          `mapstructure:"extra_args" desc:"Additional arguments appended to the command"`
+}
+
+type CliOptions struct {
+	Init    *CommandOptions `mapstructure:"init" desc:"Options for terraform init"`
+	Plan    *CommandOptions `mapstructure:"plan" desc:"Options for terraform plan, used on dry runs"`
+	Apply   *CommandOptions `mapstructure:"apply" desc:"Options for terraform apply"`
+	Destroy *CommandOptions `mapstructure:"destroy" desc:"Options for terraform apply -destroy"`
+}
+
+var (
+	// initFlags only apply to init
+	initFlags = []string{"-upgrade", "-reconfigure", "-migrate-state", "-force-copy", "-backend", "-backend-config", "-get", "-from-module", "-plugin-dir"}
+	// planFlags only apply to plan, apply and apply -destroy
+	planFlags = []string{"-parallelism", "-refresh", "-refresh-only", "-var", "-var-file", "-target", "-replace", "-destroy", "-out", "-auto-approve"}
+)
+
+// validate rejects the options that do not apply to init, or to the plan and apply commands
+func (co *CommandOptions) validate(init bool) error {
+	if co == nil {
+		return nil
+	}
+
+	unsupported := []string{}
+	for _, opt := range []struct {
+		name     string
+		set      bool
+		initOnly bool
+	}{
+		{"parallelism", co.Parallelism != nil, false},
+		{"refresh", co.Refresh != nil, false},
+		{"vars", len(co.Vars) > 0, false},
+		{"upgrade", co.Upgrade != nil, true},
+		{"reconfigure", co.Reconfigure != nil, true},
+	} {
+		if opt.set && opt.initOnly != init {
+			unsupported = append(unsupported, opt.name)
+		}
+	}
+
+	for _, arg := range co.ExtraArgs {
+		flag, _, _ := strings.Cut("-"+strings.TrimLeft(arg, "-"), "=")
+		if (init && slices.Contains(planFlags, flag)) || (!init && slices.Contains(initFlags, flag)) {
+			unsupported = append(unsupported, arg)
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s not supported", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+func (co *CliOptions) validate() error {
+	if co == nil {
+		return nil
+	}
+
+	for _, cmd := range []struct {
+		name string
+		opts *CommandOptions
+	}{
+		{"init", co.Init},
+		{"plan", co.Plan},
+		{"apply", co.Apply},
+		{"destroy", co.Destroy},
+	} {
+		if err := cmd.opts.validate(cmd.name == "init"); err != nil {
+			return fmt.Errorf("%s: %w", cmd.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (co *CommandOptions) Args() []string {
+	args := []string{}
+	if co == nil {
+		return args
+	}
+
+	if co.Parallelism != nil {
+		args = append(args, fmt.Sprintf("-parallelism=%d", *co.Parallelism))
+	}
+	if co.LockTimeout != nil {
+		args = append(args, "-lock-timeout="+*co.LockTimeout)
+	}
+	if co.Refresh != nil {
+		args = append(args, fmt.Sprintf("-refresh=%t", *co.Refresh))
+	}
+	if co.Upgrade != nil && *co.Upgrade {
+		args = append(args, "-upgrade")
+	}
+	if co.Reconfigure != nil && *co.Reconfigure {
+		args = append(args, "-reconfigure")
+	}
+
+	keys := []string{}
+	for k := range co.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-var", fmt.Sprintf("%s=%s", k, co.Vars[k]))
+	}
+
+	return append(args, co.ExtraArgs...)
+}
+
+// SavedPlanArgs returns the options that still apply when applying a saved plan, the others are planning options
+func (co *CommandOptions) SavedPlanArgs() []string {
+	args := []string{}
+	if co == nil {
+		return args
+	}
+
+	if co.Parallelism != nil {
+		args = append(args, fmt.Sprintf("-parallelism=%d", *co.Parallelism))
+	}
+	if co.LockTimeout != nil {
+		args = append(args, "-lock-timeout="+*co.LockTimeout)
+	}
+
+	return args
+}
+
+func (dest *CommandOptions) Merge(src *CommandOptions) {
+	if src == nil {
+		return
+	}
+
+	if src.Parallelism != nil {
+		dest.Parallelism = src.Parallelism
+	}
+	if src.LockTimeout != nil {
+		dest.LockTimeout = src.LockTimeout
+	}
+	if src.Refresh != nil {
+		dest.Refresh = src.Refresh
+	}
+	if src.Upgrade != nil {
+		dest.Upgrade = src.Upgrade
+	}
+	if src.Reconfigure != nil {
+		dest.Reconfigure = src.Reconfigure
+	}
+	if len(src.Vars) > 0 {
+		vars := map[string]string{}
+		for k, v := range dest.Vars {
+			vars[k] = v
+		}
+		for k, v := range src.Vars {
+			vars[k] = v
+		}
+		dest.Vars = vars
+	}
+	if len(src.ExtraArgs) > 0 {
+		dest.ExtraArgs = append(append([]string{}, dest.ExtraArgs...), src.ExtraArgs...)
+	}
+}
+
+func (dest *CliOptions) Merge(src *CliOptions) {
+	if src == nil {
+		return
+	}
+
+	for _, pair := range []struct {
+		dest **CommandOptions
+		src  *CommandOptions
+	}{
+		{&dest.Init, src.Init},
+		{&dest.Plan, src.Plan},
+		{&dest.Apply, src.Apply},
+		{&dest.Destroy, src.Destroy},
+	} {
+		if pair.src == nil {
+			continue
+		}
+		if *pair.dest == nil {
+			*pair.dest = &CommandOptions{}
+		}
+		(*pair.dest).Merge(pair.src)
+	}
+}
+
+// MergeCliOptions merges all the given options into a new one, later ones taking precedence
+func MergeCliOptions(opts ...*CliOptions) *CliOptions {
+	merged := &CliOptions{}
+	for _, o := range opts {
+		merged.Merge(o)
+	}
+
+	return merged
+}
@@ -0,0 +1,33 @@
+package terraform
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestMergeCliOptions(t *testing.T) {
+	parallelism := 5
+	merged := MergeCliOptions(
+		&CliOptions{Apply: &CommandOptions{Vars: map[string]string{"a": "1", "b": "1"}, ExtraArgs: []string{"-compact-warnings"}}},
+		&CliOptions{Apply: &CommandOptions{Parallelism: &parallelism, Vars: map[string]string{"b": "2"}, ExtraArgs: []string{"-lock=false"}}},
+	)
+
+	assert.DeepEqual(t, merged.Apply.Args(), []string{"-parallelism=5", "-var", "a=1", "-var", "b=2", "-compact-warnings", "-lock=false"})
+}
+
+func TestCliOptionsValidate(t *testing.T) {
+	upgrade := true
+	parallelism := 5
+
+	assert.NilError(t, (&CliOptions{
+		Init: &CommandOptions{Upgrade: &upgrade, ExtraArgs: []string{"-backend-config=prod.hcl"}},
+		Plan: &CommandOptions{Parallelism: &parallelism, ExtraArgs: []string{"-var-file=extra.tfvars"}},
+	}).validate())
+
+	err := (&CliOptions{Plan: &CommandOptions{Upgrade: &upgrade, ExtraArgs: []string{"--reconfigure"}}}).validate()
+	assert.Error(t, err, "plan: upgrade, --reconfigure not supported")
+
+	err = (&CliOptions{Init: &CommandOptions{Parallelism: &parallelism, ExtraArgs: []string{"-target=null_resource.a"}}}).validate()
+	assert.Error(t, err, "init: parallelism, -target=null_resource.a not supported")
+}
@@ -0,0 +1,108 @@
+package terraform
+
+import (
+	"fmt"
+
+	"github.com/zen-io/zen-core/utils"
+)
+
+// EnvOverride overrides the deployment settings of a single environment
+type EnvOverride struct {
+	TerraformDeploymentConfig `mapstructure:",squash"`
+	Deploy                    *DeployConfig `mapstructure:"deploy"`
+}
+
+func extendList(dest, src []string) []string {
+	if len(src) == 0 {
+		return dest
+	}
+
+	return append(append([]string{}, dest...), src...)
+}
+
+// validate rejects the settings that are shared by every environment of the target
+func (o *EnvOverride) validate() error {
+	for _, field := range []struct {
+		name string
+		set  bool
+	}{
+		{"terraform", o.Terraform != nil},
+		{"tflocal", o.Tflocal != nil},
+		{"tflint", o.Tflint != nil},
+		{"secrets_allowlist", o.SecretAllowlist != nil},
+		// the zen scripts are shared by the environments, only the env of deploy is set per environment
+		{"deploy.deps", o.Deploy != nil && len(o.Deploy.Deps) > 0},
+		{"deploy.pass_env", o.Deploy != nil && len(o.Deploy.PassEnv) > 0},
+		{"deploy.secret_env", o.Deploy != nil && len(o.Deploy.SecretEnv) > 0},
+		{"deploy.outs", o.Deploy != nil && len(o.Deploy.Outs) > 0},
+	} {
+		if field.set {
+			return fmt.Errorf("%s cannot be overridden per environment", field.name)
+		}
+	}
+
+	if err := o.CliOptions.validate(); err != nil {
+		return fmt.Errorf("cli_options: %w", err)
+	}
+
+	return nil
+}
+
+// Merge applies the overrides of src. Lists are extended, maps merged, and set values replace the ones in dest
+func (dest *TerraformDeploymentConfig) Merge(src *TerraformDeploymentConfig) {
+	if src == nil {
+		return
+	}
+
+	dest.VarFiles = extendList(dest.VarFiles, src.VarFiles)
+	dest.Modules = extendList(dest.Modules, src.Modules)
+	dest.ProviderConfigs = extendList(dest.ProviderConfigs, src.ProviderConfigs)
+	dest.StateRemoves = extendList(dest.StateRemoves, src.StateRemoves)
+	dest.Targets = extendList(dest.Targets, src.Targets)
+	dest.Replace = extendList(dest.Replace, src.Replace)
+	if len(src.StateMoves) > 0 {
+		dest.StateMoves = utils.MergeMaps(dest.StateMoves, src.StateMoves)
+	}
+
+	if src.Backend != nil {
+		dest.Backend = src.Backend
+	}
+	if src.BackendKey != "" {
+		dest.BackendKey = src.BackendKey
+	}
+	if src.AgeKeyEnv != "" {
+		dest.AgeKeyEnv = src.AgeKeyEnv
+	}
+	if src.TflintConfig != nil {
+		dest.TflintConfig = src.TflintConfig
+	}
+	if src.TflintPluginDir != "" {
+		dest.TflintPluginDir = src.TflintPluginDir
+	}
+
+	if src.AllowFailure != nil {
+		dest.AllowFailure = src.AllowFailure
+	}
+	if src.ForceTargeted != nil {
+		dest.ForceTargeted = src.ForceTargeted
+	}
+	if src.SecurityPlan != nil {
+		dest.SecurityPlan = src.SecurityPlan
+	}
+
+	dest.CliOptions = MergeCliOptions(dest.CliOptions, src.CliOptions)
+}
+
+func (dest *DeployConfig) Merge(src *DeployConfig) {
+	if src == nil {
+		return
+	}
+
+	dest.Deps = extendList(dest.Deps, src.Deps)
+	dest.PassEnv = extendList(dest.PassEnv, src.PassEnv)
+	dest.SecretEnv = extendList(dest.SecretEnv, src.SecretEnv)
+	dest.Outs = extendList(dest.Outs, src.Outs)
+	if len(src.Env) > 0 {
+		dest.Env = utils.MergeMaps(dest.Env, src.Env)
+	}
+}
@@ -0,0 +1,92 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	fe := useFakeExecutor(t)
+	parallelism := 5
+	tc := testConfig("dev", "prod")
+	tc.Srcs = []string{"*.tf", "*.tfvars"}
+	tc.VarFiles = []string{"common.tfvars"}
+	tc.Deploy = &DeployConfig{PassEnv: []string{"AWS_PROFILE"}}
+	tc.EnvOverrides = map[string]*EnvOverride{
+		"prod": {
+			TerraformDeploymentConfig: TerraformDeploymentConfig{
+				VarFiles:   []string{"prod.tfvars"},
+				CliOptions: &CliOptions{Apply: &CommandOptions{Parallelism: &parallelism}},
+			},
+			Deploy: &DeployConfig{Env: map[string]string{"TF_LOG": "info"}},
+		},
+	}
+	tb := getTarget(t, tc)
+	assert.DeepEqual(t, tb.Environments["prod"].Variables, map[string]string{"TF_LOG": "info"})
+	assert.DeepEqual(t, tb.Scripts["deploy"].PassEnv, []string{"AWS_PROFILE"})
+
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":       "variable \"a\" {}\n",
+		"common.tfvars": "a = 1\n",
+		"prod.tfvars":   "a = 2\n",
+	})
+
+	for env, expected := range map[string][]string{
+		"dev":  {".zen_sourcemap.json", "00-common.auto.tfvars", "main.tf"},
+		"prod": {".zen_sourcemap.json", "00-common.auto.tfvars", "01-prod.auto.tfvars", "main.tf"},
+	} {
+		entries, err := os.ReadDir(filepath.Join(root, env))
+		assert.NilError(t, err)
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		assert.DeepEqual(t, names, expected)
+	}
+
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "dev"}))
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "prod"}))
+	assert.DeepEqual(t, fe.commands(t), []string{
+		"terraform init",
+		"terraform apply -auto-approve -json",
+		"terraform init",
+		"terraform apply -auto-approve -parallelism=5 -json",
+	})
+}
+
+func TestEnvOverridesInvalid(t *testing.T) {
+	tf := "terraform"
+	tc := testConfig("dev")
+	tc.EnvOverrides = map[string]*EnvOverride{"prod": {}}
+	_, err := tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "env_overrides: unknown environment prod")
+
+	tc.EnvOverrides = map[string]*EnvOverride{"dev": {TerraformDeploymentConfig: TerraformDeploymentConfig{Terraform: &tf}}}
+	_, err = tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "env_overrides dev: terraform cannot be overridden per environment")
+
+	tc.EnvOverrides = map[string]*EnvOverride{"dev": {Deploy: &DeployConfig{SecretEnv: []string{"VAULT_TOKEN"}}}}
+	_, err = tc.GetTargets(nil)
+	assert.ErrorContains(t, err, "env_overrides dev: deploy.secret_env cannot be overridden per environment")
+}
+
+func TestEnvOverridesTurnOff(t *testing.T) {
+	fe := useFakeExecutor(t)
+	fe.respond(t, "apply", `{"@level":"error","@message":"Error: boom","type":"diagnostic","diagnostic":{"severity":"error","summary":"boom"}}`+"\n", 1)
+	allowFailure, disallowFailure := true, false
+	tc := testConfig("dev", "prod")
+	tc.AllowFailure = &allowFailure
+	tc.EnvOverrides = map[string]*EnvOverride{
+		"prod": {TerraformDeploymentConfig: TerraformDeploymentConfig{AllowFailure: &disallowFailure}},
+	}
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{"main.tf": ""})
+
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "dev"}))
+	err := runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "prod"})
+	assert.ErrorContains(t, err, "boom")
+}
@@ -0,0 +1,72 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+type planResource struct {
+	Address string                 `json:"address"`
+	Mode    string                 `json:"mode"`
+	Type    string                 `json:"type"`
+	Name    string                 `json:"name"`
+	Values  map[string]interface{} `json:"values"`
+}
+
+type planModule struct {
+	Resources    []planResource `json:"resources"`
+	ChildModules []planModule   `json:"child_modules"`
+}
+
+// planJSON is the subset of terraform show -json used by the plugin
+type planJSON struct {
+	PlannedValues struct {
+		RootModule planModule `json:"root_module"`
+	} `json:"planned_values"`
+	ResourceChanges []planResourceChange `json:"resource_changes"`
+}
+
+type planResourceChange struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Change  struct {
+		Actions      []string    `json:"actions"`
+		After        interface{} `json:"after"`
+		AfterUnknown interface{} `json:"after_unknown"`
+	} `json:"change"`
+}
+
+func (pm planModule) allResources() []planResource {
+	resources := append([]planResource{}, pm.Resources...)
+	for _, child := range pm.ChildModules {
+		resources = append(resources, child.allResources()...)
+	}
+
+	return resources
+}
+
+// planFile is the plan saved by tfShowPlan, which deploys apply once it passed the policies
+const planFile = "zen.tfplan"
+
+// tfShowPlan saves a plan to planFile and returns it decoded. The caller removes the plan file
+var tfShowPlan = func(target *zen_targets.Target, env string, planArgs ...string) (*planJSON, []byte, error) {
+	if err := terraformExecJSON(target, env, "Planning", append([]string{"plan", "-out=" + planFile}, planArgs...)); err != nil {
+		return nil, nil, fmt.Errorf("executing plan: %w", err)
+	}
+
+	out, err := terraformOutput(target, env, []string{"show", "-json", planFile})
+	if err != nil {
+		return nil, nil, fmt.Errorf("executing show: %w", err)
+	}
+
+	var plan planJSON
+	if err := json.Unmarshal(out, &plan); err != nil {
+		return nil, nil, fmt.Errorf("decoding plan: %w", err)
+	}
+
+	return &plan, out, nil
+}
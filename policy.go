@@ -0,0 +1,275 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// policiesDir is where build places the configured policy files, in every env directory
+const policiesDir = ".zen_policies"
+
+// policyAssertion must hold for the attribute of every resource a rule applies to
+type policyAssertion struct {
+	Attribute string        `yaml:"attribute"`
+	Exists    *bool         `yaml:"exists"`
+	Equals    interface{}   `yaml:"equals"`
+	NotEquals interface{}   `yaml:"not_equals"`
+	In        []interface{} `yaml:"in"`
+	NotIn     []interface{} `yaml:"not_in"`
+	Matches   string        `yaml:"matches"`
+}
+
+type policyRule struct {
+	ID            string            `yaml:"id"`
+	Description   string            `yaml:"description"`
+	Severity      string            `yaml:"severity"`
+	ResourceTypes []string          `yaml:"resource_types"`
+	Actions       []string          `yaml:"actions"`
+	Deny          bool              `yaml:"deny"`
+	Assert        []policyAssertion `yaml:"assert"`
+}
+
+// policySeverities are the report levels a rule can have, only error blocks the deploy
+var policySeverities = []string{"error", "warning", "note"}
+
+type policyFile struct {
+	Rules []policyRule `yaml:"rules"`
+}
+
+type policyRuleSet struct {
+	File  string
+	Rules []policyRule
+}
+
+// loadPolicies reads every policy file build placed in dir
+func loadPolicies(dir string) ([]policyRuleSet, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	sets := []policyRuleSet{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading policy %s: %w", entry.Name(), err)
+		}
+
+		var pf policyFile
+		if err := yaml.Unmarshal(data, &pf); err != nil {
+			return nil, fmt.Errorf("decoding policy %s: %w", entry.Name(), err)
+		}
+
+		for i, rule := range pf.Rules {
+			if rule.ID == "" {
+				return nil, fmt.Errorf("policy %s: rule %d has no id", entry.Name(), i)
+			}
+			if !rule.Deny && len(rule.Assert) == 0 {
+				return nil, fmt.Errorf("policy %s: rule %s needs either deny or assert", entry.Name(), rule.ID)
+			}
+
+			severity := strings.ToLower(rule.Severity)
+			if severity == "" {
+				severity = "error"
+			} else if !slices.Contains(policySeverities, severity) {
+				return nil, fmt.Errorf("policy %s: rule %s has severity %s, must be one of %s", entry.Name(), rule.ID, rule.Severity, strings.Join(policySeverities, ", "))
+			}
+			pf.Rules[i].Severity = severity
+		}
+
+		sets = append(sets, policyRuleSet{File: entry.Name(), Rules: pf.Rules})
+	}
+
+	return sets, nil
+}
+
+func (rule *policyRule) appliesTo(rc planResourceChange) bool {
+	if rc.Mode != "managed" {
+		return false
+	}
+
+	if len(rule.ResourceTypes) > 0 && !slices.ContainsFunc(rule.ResourceTypes, func(pattern string) bool {
+		matched, _ := path.Match(pattern, rc.Type)
+		return matched
+	}) {
+		return false
+	}
+
+	actions := rule.Actions
+	if len(actions) == 0 {
+		actions = []string{"create", "update"}
+	}
+
+	for _, action := range rc.Change.Actions {
+		if slices.Contains(actions, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupAttribute resolves a dotted path (numbers index into lists) in the planned values. known is false
+// when terraform will only know the value after apply
+func lookupAttribute(after, afterUnknown interface{}, attr string) (value interface{}, exists, known bool) {
+	value, unknown := after, afterUnknown
+	for _, part := range strings.Split(attr, ".") {
+		if u, ok := unknown.(bool); ok && u {
+			return nil, true, false
+		}
+
+		value = childValue(value, part)
+		unknown = childValue(unknown, part)
+	}
+
+	if u, ok := unknown.(bool); ok && u {
+		return nil, true, false
+	}
+
+	return value, value != nil, true
+}
+
+func childValue(v interface{}, key string) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		return c[key]
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(c) {
+			return c[i]
+		}
+	}
+
+	return nil
+}
+
+func policyEqual(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// check returns why the assertion does not hold, or an empty string when it does
+func (pa *policyAssertion) check(rc planResourceChange) string {
+	value, exists, known := lookupAttribute(rc.Change.After, rc.Change.AfterUnknown, pa.Attribute)
+	if !known {
+		// cannot be judged until applied
+		return ""
+	}
+
+	if pa.Exists != nil && *pa.Exists != exists {
+		if exists {
+			return fmt.Sprintf("%s must not be set", pa.Attribute)
+		}
+		return fmt.Sprintf("%s must be set", pa.Attribute)
+	}
+
+	if pa.Equals != nil && !policyEqual(value, pa.Equals) {
+		return fmt.Sprintf("%s is %v, must be %v", pa.Attribute, value, pa.Equals)
+	}
+
+	if pa.NotEquals != nil && policyEqual(value, pa.NotEquals) {
+		return fmt.Sprintf("%s must not be %v", pa.Attribute, pa.NotEquals)
+	}
+
+	if len(pa.In) > 0 && !slices.ContainsFunc(pa.In, func(i interface{}) bool { return policyEqual(value, i) }) {
+		return fmt.Sprintf("%s is %v, must be one of %v", pa.Attribute, value, pa.In)
+	}
+
+	if len(pa.NotIn) > 0 && slices.ContainsFunc(pa.NotIn, func(i interface{}) bool { return policyEqual(value, i) }) {
+		return fmt.Sprintf("%s must not be %v", pa.Attribute, value)
+	}
+
+	if pa.Matches != "" {
+		re, err := regexp.Compile(pa.Matches)
+		if err != nil {
+			return fmt.Sprintf("invalid pattern %s: %s", pa.Matches, err)
+		}
+		if !exists || !re.MatchString(fmt.Sprint(value)) {
+			return fmt.Sprintf("%s is %v, must match %s", pa.Attribute, value, pa.Matches)
+		}
+	}
+
+	return ""
+}
+
+func evaluatePolicies(sets []policyRuleSet, plan *planJSON) []finding {
+	findings := []finding{}
+	for _, set := range sets {
+		for _, rule := range set.Rules {
+			for _, rc := range plan.ResourceChanges {
+				if !rule.appliesTo(rc) {
+					continue
+				}
+
+				reasons := []string{}
+				if rule.Deny {
+					reasons = append(reasons, fmt.Sprintf("%s is not allowed", strings.Join(rc.Change.Actions, "/")))
+				}
+				for _, assertion := range rule.Assert {
+					if reason := assertion.check(rc); reason != "" {
+						reasons = append(reasons, reason)
+					}
+				}
+
+				for _, reason := range reasons {
+					msg := fmt.Sprintf("%s: %s", rc.Address, reason)
+					if rule.Description != "" {
+						msg = fmt.Sprintf("%s: %s (%s)", rc.Address, rule.Description, reason)
+					}
+					findings = append(findings, finding{RuleID: rule.ID, Level: rule.Severity, Message: msg, File: filepath.Join(policiesDir, set.File)})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].RuleID < findings[j].RuleID
+	})
+
+	return findings
+}
+
+// checkPlan plans the env and evaluates the policies in its directory and the required tags against the plan
+func checkPlan(target *zen_targets.Target, env string, planArgs []string, requiredTags []string) error {
+	sets, err := loadPolicies(filepath.Join(target.Cwd, policiesDir))
+	if err != nil {
+		return err
+	}
+
+	target.SetStatus(fmt.Sprintf("Evaluating policies for %s", target.Qn()))
+	plan, _, err := tfShowPlan(target, env, planArgs...)
+	if err != nil {
+		return err
+	}
+
+	findings := append(evaluatePolicies(sets, plan), checkRequiredTags(plan, requiredTags)...)
+	if err := writeReports(target, "policy", "zen terraform policies", findings); err != nil {
+		return err
+	}
+
+	for _, f := range findings {
+		if f.Level != "error" {
+			target.Debugln(f.String())
+		}
+	}
+
+	if errs := countErrors(findings); errs > 0 {
+		return findingsError(findings, "%d policy violations", errs)
+	}
+
+	return nil
+}
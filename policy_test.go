@@ -0,0 +1,62 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestLoadPoliciesSeverity(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.yaml": "rules:\n  - id: a\n    deny: true\n  - id: b\n    severity: Warning\n    deny: true\n",
+	})
+
+	sets, err := loadPolicies(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, sets[0].Rules[0].Severity, "error")
+	assert.Equal(t, sets[0].Rules[1].Severity, "warning")
+
+	writeFiles(t, dir, map[string]string{"b.yaml": "rules:\n  - id: c\n    severity: HIGH\n    deny: true\n"})
+	_, err = loadPolicies(dir)
+	assert.Error(t, err, "policy b.yaml: rule c has severity HIGH, must be one of error, warning, note")
+}
+
+func TestDeployPolicies(t *testing.T) {
+	fe := useFakeExecutor(t)
+	fe.respond(t, "show", `{"resource_changes":[{"address":"aws_s3_bucket.a","mode":"managed","type":"aws_s3_bucket","name":"a","change":{"actions":["create"],"after":{"acl":"private"}}}]}`, 0)
+	tc := testConfig("dev")
+	tc.Policies = []string{"policies/*.yaml"}
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":             "",
+		"policies/s3.yaml":    "rules:\n  - id: private-buckets\n    resource_types: [aws_s3_bucket]\n    assert:\n      - attribute: acl\n        equals: private\n",
+		"policies/other.yaml": "rules:\n  - id: no-deletes\n    actions: [delete]\n    deny: true\n",
+	})
+
+	assert.NilError(t, runScript(t, tb, root, "deploy", &zen_targets.RuntimeContext{Env: "dev"}))
+	// the plan the policies passed is the one applied
+	assert.DeepEqual(t, fe.commands(t), []string{
+		"terraform init",
+		"terraform plan -out=zen.tfplan -json",
+		"terraform show -json zen.tfplan",
+		"terraform apply -json zen.tfplan",
+	})
+
+	_, err := os.Stat(filepath.Join(root, "dev", planFile))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestBuildPoliciesSameName(t *testing.T) {
+	tc := testConfig("dev")
+	tc.Policies = []string{"a/policy.yaml", "b/policy.yaml"}
+	tb := getTarget(t, tc)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.tf": "", "a/policy.yaml": "rules: []\n", "b/policy.yaml": "rules: []\n"})
+	err := runScript(t, tb, root, "build", &zen_targets.RuntimeContext{})
+	assert.Error(t, err, "policies a/policy.yaml and b/policy.yaml have the same file name")
+}
@@ -0,0 +1,242 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"golang.org/x/exp/slices"
+)
+
+type uiResource struct {
+	Addr string `json:"addr"`
+}
+
+type uiHook struct {
+	Resource       uiResource `json:"resource"`
+	Action         string     `json:"action"`
+	ElapsedSeconds int        `json:"elapsed_seconds"`
+}
+
+type uiChanges struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Remove    int    `json:"remove"`
+	Operation string `json:"operation"`
+}
+
+type uiPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type uiRange struct {
+	Filename string `json:"filename"`
+	Start    uiPos  `json:"start"`
+	End      uiPos  `json:"end"`
+}
+
+type uiDiagnostic struct {
+	Severity string   `json:"severity"`
+	Summary  string   `json:"summary"`
+	Detail   string   `json:"detail"`
+	Address  string   `json:"address"`
+	Range    *uiRange `json:"range"`
+}
+
+type uiTestRun struct {
+	Path     string `json:"path"`
+	Run      string `json:"run"`
+	Progress string `json:"progress"`
+	Status   string `json:"status"`
+	Elapsed  int    `json:"elapsed"`
+}
+
+// uiMessage is a line of terraform's machine readable UI (-json)
+type uiMessage struct {
+	Level      string        `json:"@level"`
+	Message    string        `json:"@message"`
+	Type       string        `json:"type"`
+	TestFile   string        `json:"@testfile"`
+	TestRun    string        `json:"@testrun"`
+	Hook       *uiHook       `json:"hook"`
+	Changes    *uiChanges    `json:"changes"`
+	Diagnostic *uiDiagnostic `json:"diagnostic"`
+	Run        *uiTestRun    `json:"test_run"`
+}
+
+// render returns the human readable form of the message
+func (m *uiMessage) render() string {
+	if m.Diagnostic == nil {
+		return m.Message
+	}
+
+	severity := m.Diagnostic.Severity
+	if severity != "" {
+		severity = strings.ToUpper(severity[:1]) + severity[1:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s: %s", severity, m.Diagnostic.Summary))
+	if m.Diagnostic.Range != nil {
+		sb.WriteString(fmt.Sprintf("\n  on %s line %d", m.Diagnostic.Range.Filename, m.Diagnostic.Range.Start.Line))
+	}
+	if m.Diagnostic.Address != "" {
+		sb.WriteString(fmt.Sprintf(", in %s", m.Diagnostic.Address))
+	}
+	if m.Diagnostic.Detail != "" {
+		sb.WriteString("\n\n" + m.Diagnostic.Detail)
+	}
+
+	return sb.String()
+}
+
+// progressWriter consumes terraform's -json stream, reporting progress through the target status and
+// keeping a human readable log of the run
+type progressWriter struct {
+	target    *zen_targets.Target
+	verb      string
+	total     int
+	completed int
+	partial   []byte
+	log       bytes.Buffer
+	sources   sourceMap
+	tests     []*testResult
+	testLogs  map[string]string
+}
+
+func newProgressWriter(target *zen_targets.Target, verb string) *progressWriter {
+	return &progressWriter{target: target, verb: verb, sources: loadSourceMap(target.Cwd), testLogs: map[string]string{}}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.partial = append(pw.partial, p...)
+
+	for {
+		i := bytes.IndexByte(pw.partial, '\n')
+		if i == -1 {
+			break
+		}
+
+		pw.handleLine(pw.partial[:i])
+		pw.partial = pw.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (pw *progressWriter) handleLine(line []byte) {
+	var msg uiMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		// not part of the json stream, keep it as is
+		pw.logLine(string(line))
+		return
+	}
+
+	pw.sources.rewriteDiagnostic(msg.Diagnostic)
+	pw.logLine(msg.render())
+
+	switch msg.Type {
+	case "change_summary":
+		if msg.Changes != nil && msg.Changes.Operation == "plan" {
+			pw.total = msg.Changes.Add + msg.Changes.Change + msg.Changes.Remove
+		}
+	case "planned_change", "refresh_start":
+		if msg.Hook != nil {
+			pw.target.SetStatus(fmt.Sprintf("%s %s: %s", pw.verb, pw.target.Qn(), msg.Hook.Resource.Addr))
+		}
+	case "apply_start", "apply_progress":
+		pw.setApplyStatus(msg.Hook)
+	case "apply_complete", "apply_errored":
+		pw.completed++
+		pw.setApplyStatus(msg.Hook)
+	case "test_run":
+		if msg.Run == nil {
+			break
+		}
+
+		pw.target.SetStatus(fmt.Sprintf("%s %s: %s/%s", pw.verb, pw.target.Qn(), msg.Run.Path, msg.Run.Run))
+		if msg.Run.Progress == "complete" {
+			pw.tests = append(pw.tests, &testResult{
+				File:    pw.sources.resolve(msg.Run.Path),
+				Run:     msg.Run.Run,
+				Status:  msg.Run.Status,
+				Elapsed: time.Duration(msg.Run.Elapsed) * time.Millisecond,
+				Output:  pw.testLogs[msg.Run.Path+"/"+msg.Run.Run],
+			})
+		}
+	case "diagnostic":
+		if msg.TestRun != "" {
+			// diagnostics of a run are reported before it completes, keep them for when it does
+			pw.testLogs[msg.TestFile+"/"+msg.TestRun] += msg.render() + "\n"
+		}
+	}
+}
+
+func (pw *progressWriter) setApplyStatus(hook *uiHook) {
+	if hook == nil {
+		return
+	}
+
+	var count string
+	if pw.total > 0 {
+		count = fmt.Sprintf(" %d/%d", pw.completed, pw.total)
+	}
+
+	pw.target.SetStatus(fmt.Sprintf(
+		"%s %s%s: %s (%s)",
+		pw.verb, pw.target.Qn(), count, hook.Resource.Addr, time.Duration(hook.ElapsedSeconds)*time.Second,
+	))
+}
+
+func (pw *progressWriter) logLine(line string) {
+	if line == "" {
+		return
+	}
+
+	line = secretsOf(pw.target).redact(line)
+	pw.target.Debugln(line)
+	pw.log.WriteString(line + "\n")
+}
+
+// Flush handles any trailing output that did not end with a newline
+func (pw *progressWriter) Flush() {
+	if len(pw.partial) > 0 {
+		pw.handleLine(pw.partial)
+		pw.partial = nil
+	}
+}
+
+func (pw *progressWriter) String() string {
+	return pw.log.String()
+}
+
+func (pw *progressWriter) run(env string, args []string) error {
+	// commands ending with a positional argument have the -json flag before it already
+	if !slices.Contains(args, "-json") {
+		args = append(args, "-json")
+	}
+
+	cmd := newTerraformCmd(pw.target, env, args)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	err := toolExecutor.Run(pw.target, cmd)
+	pw.Flush()
+	if _, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("tf exec: %s", pw.String())
+	} else if err != nil {
+		return fmt.Errorf("tf exec: %w\n%s", err, pw.String())
+	}
+
+	return nil
+}
+
+// terraformExecJSON runs a terraform command with -json, driving the target status from its progress
+var terraformExecJSON = func(target *zen_targets.Target, env, verb string, args []string) error {
+	return newProgressWriter(target, verb).run(env, args)
+}
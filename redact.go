@@ -0,0 +1,227 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// redactedValue replaces the secrets in the logs, errors and reports
+const redactedValue = "***"
+
+// minSecretLength keeps short values, like "1", "true" or "null", from being masked everywhere
+const minSecretLength = 5
+
+// redactor masks the secret values it was given
+type redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+func newRedactor() *redactor {
+	return &redactor{secrets: map[string]bool{}}
+}
+
+// redactors holds the redactor of each target while one of its scripts runs, so that the secrets of a run are
+// forgotten once it is done
+var redactors sync.Map
+
+// secretsOf returns the redactor of the target's current run
+func secretsOf(target *zen_targets.Target) *redactor {
+	r, _ := redactors.LoadOrStore(target, newRedactor())
+	return r.(*redactor)
+}
+
+func (r *redactor) add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range values {
+		// multiline secrets, like private keys, are also masked line by line
+		for _, s := range append(strings.Split(v, "\n"), v) {
+			if len(strings.TrimSpace(s)) >= minSecretLength && !r.secrets[s] {
+				r.secrets[s] = true
+				r.replacer = nil
+			}
+		}
+	}
+}
+
+// addValues adds every string in the values, walking lists and maps
+func (r *redactor) addValues(values ...interface{}) {
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			r.add(v)
+		case []interface{}:
+			r.addValues(v...)
+		case map[string]interface{}:
+			for _, item := range v {
+				r.addValues(item)
+			}
+		}
+	}
+}
+
+// addCty adds every string in a cty value, walking collections and objects
+func (r *redactor) addCty(v cty.Value) {
+	if v.IsNull() || !v.IsKnown() {
+		return
+	}
+
+	if v.Type() == cty.String {
+		r.add(v.AsString())
+	} else if v.CanIterateElements() {
+		for it := v.ElementIterator(); it.Next(); {
+			_, item := it.Element()
+			r.addCty(item)
+		}
+	}
+}
+
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	replacer, count := r.replacer, len(r.secrets)
+	r.mu.RUnlock()
+
+	if count == 0 {
+		return s
+	}
+
+	if replacer == nil {
+		r.mu.Lock()
+		values := []string{}
+		for v := range r.secrets {
+			values = append(values, v)
+		}
+		// longest first, so that a secret containing another one is masked as a whole
+		sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+		pairs := []string{}
+		for _, v := range values {
+			pairs = append(pairs, v, redactedValue)
+		}
+		r.replacer = strings.NewReplacer(pairs...)
+		replacer = r.replacer
+		r.mu.Unlock()
+	}
+
+	return replacer.Replace(s)
+}
+
+// addSecretEnv adds the values of the target secret environment variables
+func (r *redactor) addSecretEnv(target *zen_targets.Target) {
+	for _, name := range target.PassSecretEnv {
+		if v, ok := target.EnvVars()[name]; ok {
+			r.add(v)
+		} else {
+			r.add(os.Getenv(name))
+		}
+	}
+}
+
+type tfOutput struct {
+	Sensitive bool        `json:"sensitive"`
+	Value     interface{} `json:"value"`
+}
+
+// hasSensitiveOutputs tells whether the .tf and .tf.json files of dir declare any output as sensitive
+func hasSensitiveOutputs(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	parser := hclparse.NewParser()
+	for _, entry := range entries {
+		if entry.IsDir() || !isTfFile(entry.Name()) {
+			continue
+		}
+
+		file, _, err := parseTerraformFile(parser, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return false, err
+		}
+
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "output", LabelNames: []string{"name"}}},
+		})
+		for _, block := range content.Blocks {
+			attrs, _, _ := block.Body.PartialContent(&hcl.BodySchema{Attributes: []hcl.AttributeSchema{{Name: "sensitive"}}})
+			attr, ok := attrs.Attributes["sensitive"]
+			if !ok {
+				continue
+			}
+
+			if v, diags := attr.Expr.Value(nil); !diags.HasErrors() && v.Type() == cty.Bool && v.IsKnown() && !v.IsNull() && v.True() {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// tfSensitiveOutputs returns the values of the outputs in the state that terraform marks as sensitive
+var tfSensitiveOutputs = func(target *zen_targets.Target, env string) ([]interface{}, error) {
+	out, err := terraformOutput(target, env, []string{"output", "-json"})
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs map[string]tfOutput
+	if err := json.Unmarshal(out, &outputs); err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{}
+	for _, o := range outputs {
+		if o.Sensitive {
+			values = append(values, o.Value)
+		}
+	}
+
+	return values, nil
+}
+
+// redactedError masks the secrets in the message of the error it wraps
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// withRedaction gives the target a new redactor for the script, registering its secret environment, and masks
+// the secrets in the error it returns
+func withRedaction(fn func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error) func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	return func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+		r := newRedactor()
+		redactors.Store(target, r)
+		defer redactors.Delete(target)
+
+		r.addSecretEnv(target)
+
+		if err := fn(target, runCtx); err != nil {
+			return &redactedError{msg: r.redact(err.Error()), err: err}
+		}
+
+		return nil
+	}
+}
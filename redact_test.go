@@ -0,0 +1,44 @@
+package terraform
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor()
+	assert.Equal(t, r.redact("nothing to hide"), "nothing to hide")
+
+	r.add("abc", "s3cr3t", "s3cr3t-and-more", "-----BEGIN KEY-----\nMIIEvQIBADANBgkq\n-----END KEY-----")
+	r.addValues(map[string]interface{}{"list": []interface{}{"nested-value", 42}})
+
+	assert.Equal(t, r.redact("token s3cr3t-and-more, s3cr3t and abc"), "token ***, *** and abc")
+	assert.Equal(t, r.redact(`"key": "MIIEvQIBADANBgkq"`), `"key": "***"`)
+	assert.Equal(t, r.redact("got nested-value"), "got ***")
+}
+
+func TestRedactorShortValues(t *testing.T) {
+	r := newRedactor()
+	r.add("true", "null", "false")
+
+	assert.Equal(t, r.redact("enabled = true, value = null, other = false"), "enabled = true, value = null, other = ***")
+}
+
+func TestWithRedaction(t *testing.T) {
+	target := &zen_targets.Target{Name: "infra", Env: map[string]string{"TOKEN": "s3cr3t"}, PassSecretEnv: []string{"TOKEN"}, TaskLogger: nopLogger{}}
+	run := withRedaction(func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+		return fmt.Errorf("using s3cr3t: %w", os.ErrNotExist)
+	})
+
+	err := run(target, &zen_targets.RuntimeContext{})
+	assert.Error(t, err, "using ***: file does not exist")
+	assert.Assert(t, errors.Is(err, os.ErrNotExist))
+
+	// the secrets of a run are forgotten once it is done
+	assert.Equal(t, secretsOf(target).redact("s3cr3t"), "s3cr3t")
+}
@@ -0,0 +1,269 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+var addressSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "resource", LabelNames: []string{"type", "name"}},
+		{Type: "data", LabelNames: []string{"type", "name"}},
+		{Type: "module", LabelNames: []string{"name"}},
+	},
+}
+
+type movedBlock struct {
+	From string
+	To   string
+}
+
+func (mb movedBlock) String() string {
+	return fmt.Sprintf("moved {\n  from = %s\n  to   = %s\n}\n", mb.From, mb.To)
+}
+
+// movedFile is where the moved script writes the blocks it proposes, to be reviewed and added to the srcs. Terraform
+// does not load it, so a deploy of the same build output does not apply them unreviewed
+const movedFile = "moved.tf.proposed"
+
+func writeMovedBlocks(dir string, moves []movedBlock) error {
+	blocks := []string{}
+	for _, mb := range moves {
+		blocks = append(blocks, mb.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, movedFile), []byte(strings.Join(blocks, "\n")), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", movedFile, err)
+	}
+
+	return nil
+}
+
+// parseResourceAddresses returns the root module addresses (resources, data sources and module calls)
+// declared in the .tf and .tf.json files of dir
+func parseResourceAddresses(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isTfFile(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if files[path], err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	return parseAddresses(files)
+}
+
+func isTfFile(name string) bool {
+	return strings.HasSuffix(name, ".tf") || strings.HasSuffix(name, ".tf.json")
+}
+
+// parseAddresses returns the root module addresses declared in the files, by file name
+func parseAddresses(files map[string][]byte) ([]string, error) {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parser := hclparse.NewParser()
+	addresses := []string{}
+	for _, name := range names {
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(name, ".tf") {
+			file, diags = parser.ParseHCL(files[name], name)
+		} else if strings.HasSuffix(name, ".tf.json") {
+			file, diags = parser.ParseJSON(files[name], name)
+		} else {
+			continue
+		}
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("parsing %s: %s", name, diags.Error())
+		}
+
+		content, _, diags := file.Body.PartialContent(addressSchema)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("decoding %s: %s", name, diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			switch block.Type {
+			case "resource":
+				addresses = append(addresses, strings.Join(block.Labels, "."))
+			case "data":
+				addresses = append(addresses, "data."+strings.Join(block.Labels, "."))
+			case "module":
+				addresses = append(addresses, "module."+block.Labels[0])
+			}
+		}
+	}
+
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// gitTfFiles returns the .tf and .tf.json files directly in the dirs of the package at a git revision, by path
+// relative to the package
+var gitTfFiles = func(pkgDir, rev string, dirs []string) (map[string][]byte, error) {
+	args := []string{"-C", pkgDir, "ls-tree", "--name-only", rev, "--"}
+	for _, dir := range dirs {
+		args = append(args, "./"+dir+"/")
+	}
+
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing files at %s: %w", rev, err)
+	}
+
+	files := map[string][]byte{}
+	for _, name := range strings.Fields(string(out)) {
+		if !isTfFile(name) {
+			continue
+		}
+
+		if files[name], err = exec.Command("git", "-C", pkgDir, "show", fmt.Sprintf("%s:./%s", rev, name)).Output(); err != nil {
+			return nil, fmt.Errorf("reading %s at %s: %w", name, rev, err)
+		}
+	}
+
+	return files, nil
+}
+
+// packageDir returns the directory of the package in the repository. The build sandbox is not a repository
+func packageDir(target *zen_targets.Target) string {
+	if dir := target.Path(); dir != "" {
+		return dir
+	}
+
+	return target.Cwd
+}
+
+// previousAddresses returns the addresses declared in the directories the srcs of envDir were taken from, at a
+// previous git revision. Looking at whole directories includes the files that have since been removed
+func previousAddresses(target *zen_targets.Target, envDir, rev string) ([]string, error) {
+	seen := map[string]bool{}
+	dirs := []string{}
+	for built, original := range loadSourceMap(envDir) {
+		if !isTfFile(built) || filepath.IsAbs(original) || strings.HasPrefix(original, "..") {
+			continue
+		}
+
+		if dir := filepath.Dir(original); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+	sort.Strings(dirs)
+
+	files, err := gitTfFiles(packageDir(target), rev, dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAddresses(files)
+}
+
+// normalizeStateAddresses reduces the addresses reported by `terraform state list` to the root module
+// addresses they were declared with, dropping instance keys and collapsing resources inside modules
+// into their module call
+func normalizeStateAddresses(addresses []string) []string {
+	indexRe := regexp.MustCompile(`\[[^\]]*\]`)
+
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, addr := range addresses {
+		addr = indexRe.ReplaceAllString(addr, "")
+		parts := strings.Split(addr, ".")
+		if parts[0] == "module" && len(parts) > 1 {
+			addr = "module." + parts[1]
+		}
+
+		if !seen[addr] {
+			seen[addr] = true
+			normalized = append(normalized, addr)
+		}
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
+
+// addressKind returns what an address must match to be considered a rename: the resource type
+// for resources and data sources, or "module" for module calls
+func addressKind(addr string) string {
+	parts := strings.Split(addr, ".")
+	if parts[0] == "data" && len(parts) > 1 {
+		return "data." + parts[1]
+	}
+
+	return parts[0]
+}
+
+// proposeMovedBlocks compares the previous and current addresses and proposes a moved block for every
+// kind that has exactly one address removed and one added. Anything else is returned as ambiguous, so
+// that it is reviewed by hand instead of silently destroyed and recreated
+func proposeMovedBlocks(previous, current []string) (moves []movedBlock, ambiguous []string) {
+	prevSet := map[string]bool{}
+	for _, p := range previous {
+		prevSet[p] = true
+	}
+	currSet := map[string]bool{}
+	for _, c := range current {
+		currSet[c] = true
+	}
+
+	removed := map[string][]string{}
+	for _, p := range previous {
+		if !currSet[p] {
+			removed[addressKind(p)] = append(removed[addressKind(p)], p)
+		}
+	}
+	added := map[string][]string{}
+	for _, c := range current {
+		if !prevSet[c] {
+			added[addressKind(c)] = append(added[addressKind(c)], c)
+		}
+	}
+
+	kinds := []string{}
+	for kind := range removed {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		if strings.HasPrefix(kind, "data.") {
+			// data sources hold no state worth moving
+			continue
+		}
+
+		if len(removed[kind]) == 1 && len(added[kind]) == 1 {
+			moves = append(moves, movedBlock{From: removed[kind][0], To: added[kind][0]})
+		} else if len(added[kind]) > 0 {
+			ambiguous = append(ambiguous, removed[kind]...)
+		}
+	}
+
+	return
+}
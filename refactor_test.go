@@ -0,0 +1,68 @@
+package terraform
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNormalizeStateAddresses(t *testing.T) {
+	assert.DeepEqual(t, normalizeStateAddresses([]string{
+		`aws_s3_bucket.logs`,
+		`aws_instance.web[0]`,
+		`aws_instance.web[1]`,
+		`module.network.aws_vpc.main`,
+		`module.network.module.subnets["a"].aws_subnet.this`,
+		`module.dns["prod"].aws_route53_zone.main`,
+		`data.aws_caller_identity.current`,
+	}), []string{
+		"aws_instance.web",
+		"aws_s3_bucket.logs",
+		"data.aws_caller_identity.current",
+		"module.dns",
+		"module.network",
+	})
+}
+
+func TestProposeMovedBlocks(t *testing.T) {
+	moves, ambiguous := proposeMovedBlocks(
+		[]string{"aws_s3_bucket.logs", "aws_instance.a", "aws_instance.b", "data.aws_ami.old", "module.net", "aws_iam_role.gone"},
+		[]string{"aws_s3_bucket.audit_logs", "aws_instance.c", "aws_instance.d", "data.aws_ami.new", "module.network"},
+	)
+
+	assert.DeepEqual(t, moves, []movedBlock{
+		{From: "aws_s3_bucket.logs", To: "aws_s3_bucket.audit_logs"},
+		{From: "module.net", To: "module.network"},
+	})
+	// two instances renamed at once cannot be told apart, and a removed role with nothing added is not a move
+	assert.DeepEqual(t, ambiguous, []string{"aws_instance.a", "aws_instance.b"})
+}
+
+func TestPreviousAddresses(t *testing.T) {
+	prev := gitTfFiles
+	t.Cleanup(func() { gitTfFiles = prev })
+	gitTfFiles = func(pkgDir, rev string, dirs []string) (map[string][]byte, error) {
+		assert.Equal(t, rev, "HEAD~1")
+		assert.DeepEqual(t, dirs, []string{".", "overlays/prod"})
+
+		return map[string][]byte{
+			"main.tf":                 []byte("resource \"aws_s3_bucket\" \"logs\" {}\nmodule \"net\" {\n  source = \"./net\"\n}\n"),
+			"removed.tf":              []byte("data \"aws_ami\" \"old\" {}\n"),
+			"overlays/prod/waf.tf":    []byte("resource \"aws_wafv2_web_acl\" \"main\" {}\n"),
+			"overlays/prod/README":    []byte("not terraform"),
+			"overlays/prod/x.tf.json": []byte(`{"resource": {"null_resource": {"j": {}}}}`),
+		}, nil
+	}
+
+	dir := t.TempDir()
+	assert.NilError(t, sourceMap{
+		"main.tf":   "main.tf",
+		"waf.tf":    "overlays/prod/waf.tf",
+		"vars.json": "vars/prod.json",
+		"net":       "../shared/net",
+	}.save(dir))
+
+	addresses, err := previousAddresses(newTestTarget(t, getTarget(t, testConfig("prod")), dir), dir, "HEAD~1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, addresses, []string{"aws_s3_bucket.logs", "aws_wafv2_web_acl.main", "data.aws_ami.old", "module.net", "null_resource.j"})
+}
@@ -0,0 +1,262 @@
+package terraform
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// finding is a single result reported by one of the checking scripts (validate, fmt, lint...)
+type finding struct {
+	RuleID  string
+	Level   string // error, warning or note
+	Message string
+	File    string
+	Line    int
+	Column  int
+}
+
+func (f finding) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", loc, f.Line)
+	}
+
+	return fmt.Sprintf("%s: [%s] %s (%s)", f.Level, f.RuleID, f.Message, loc)
+}
+
+func countErrors(findings []finding) int {
+	count := 0
+	for _, f := range findings {
+		if f.Level == "error" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// findingsError lists the findings under the message
+func findingsError(findings []finding, format string, args ...interface{}) error {
+	msgs := []string{}
+	for _, f := range findings {
+		msgs = append(msgs, f.String())
+	}
+
+	return fmt.Errorf("%s:\n%s", fmt.Sprintf(format, args...), strings.Join(msgs, "\n"))
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+func writeJUnit(path string, suite junitTestSuite) error {
+	suite.Tests = len(suite.TestCases)
+	suite.Failures, suite.Skipped, suite.Time = 0, 0, 0
+	for _, tc := range suite.TestCases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Time += tc.Time
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding junit report: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("creating report dir: %w", err)
+	}
+
+	if err := os.WriteFile(path, append([]byte(xml.Header), data...), 0644); err != nil {
+		return fmt.Errorf("writing junit report: %w", err)
+	}
+
+	return nil
+}
+
+// writeFindingsJUnit writes a test case per finding, errors being failures. A single passing test case is
+// written when there are no findings, so that the suite is never empty
+func writeFindingsJUnit(path, name string, findings []finding) error {
+	suite := junitTestSuite{Name: name}
+	for _, f := range findings {
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("%s %s:%d", f.RuleID, f.File, f.Line),
+			Classname: name,
+		}
+		if f.Level == "error" {
+			tc.Failure = &junitFailure{Message: f.Message, Type: f.RuleID, Text: f.String()}
+		} else {
+			tc.SystemOut = f.String()
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	if len(suite.TestCases) == 0 {
+		suite.TestCases = append(suite.TestCases, junitTestCase{Name: name, Classname: name})
+	}
+
+	return writeJUnit(path, suite)
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+func writeSarif(path, tool string, findings []finding) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: tool, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+
+	rules := map[string]bool{}
+	for _, f := range findings {
+		rules[f.RuleID] = true
+
+		result := sarifResult{
+			RuleID:  f.RuleID,
+			Level:   f.Level,
+			Message: sarifMessage{Text: f.Message},
+		}
+		if f.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)}}}
+			if f.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.Line, StartColumn: f.Column}
+			}
+			result.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	ruleIDs := []string{}
+	for id := range rules {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	for _, id := range ruleIDs {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: id})
+	}
+
+	data, err := json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sarif report: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("creating report dir: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing sarif report: %w", err)
+	}
+
+	return nil
+}
+
+// writeReports writes the findings as <name>.junit.xml and <name>.sarif into the target cwd, with the secrets
+// of the target masked
+func writeReports(target *zen_targets.Target, name, tool string, findings []finding) error {
+	redacted := make([]finding, len(findings))
+	for i, f := range findings {
+		f.Message = secretsOf(target).redact(f.Message)
+		redacted[i] = f
+	}
+
+	if err := writeFindingsJUnit(filepath.Join(target.Cwd, name+".junit.xml"), name, redacted); err != nil {
+		return err
+	}
+
+	return writeSarif(filepath.Join(target.Cwd, name+".sarif"), tool, redacted)
+}
+
+func reportOuts(name string) []string {
+	return []string{name + ".junit.xml", name + ".sarif"}
+}
@@ -0,0 +1,268 @@
+package terraform
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"golang.org/x/exp/slices"
+)
+
+type secretPattern struct {
+	ID          string
+	Description string
+	Re          *regexp.Regexp // the secret is the last submatch
+}
+
+// secretPatterns are the known credential formats. Their ids can be suppressed with zen:ignore comments
+var secretPatterns = []secretPattern{
+	{"ZEN_TF_101", "AWS access key id", regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`)},
+	{"ZEN_TF_102", "AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[=:]\s*["']([A-Za-z0-9/+=]{40})["']`)},
+	{"ZEN_TF_103", "private key", regexp.MustCompile(`(-----BEGIN (?:[A-Z]+ )?PRIVATE KEY(?: BLOCK)?-----)`)},
+	{"ZEN_TF_104", "GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,})\b`)},
+	{"ZEN_TF_105", "Slack token", regexp.MustCompile(`\b(xox[abprs]-[A-Za-z0-9-]{10,})\b`)},
+	{"ZEN_TF_106", "Google API key", regexp.MustCompile(`\b(AIza[0-9A-Za-z_\-]{35})\b`)},
+	{"ZEN_TF_107", "hardcoded credential", regexp.MustCompile(`(?i)\b(?:password|passwd|secret|token|api_?key|access_?key)["']?\s*[=:]\s*"([^"$%{}\s]{8,})"`)},
+}
+
+// highEntropyID reports quoted strings random enough to be a secret
+const highEntropyID = "ZEN_TF_108"
+
+var (
+	quotedRe       = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	entropyTokenRe = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{20,}`)
+	hexRe          = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// secretsSrcs are the src groups the scan reads, besides the backends and env srcs
+var secretsSrcs = []string{"_srcs", "_data", "providers", "modules"}
+
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	entropy := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// isHighEntropy tells whether the token looks random. The entropy of a string cannot exceed log2 of its length,
+// so the threshold is a share of it, capped for long tokens
+func isHighEntropy(token string) bool {
+	if hexRe.MatchString(token) {
+		return len(token) >= 32 && shannonEntropy(token) > 3.0
+	}
+
+	return shannonEntropy(token) > math.Min(4.5, 0.9*math.Log2(float64(len(token))))
+}
+
+// maskSecret keeps enough of a secret to find it, without disclosing it in the reports
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return redactedValue
+	}
+
+	return s[:4] + redactedValue
+}
+
+// loadSecretsAllowlist reads the regular expressions of the allowlist, one per line, # starting a comment
+func loadSecretsAllowlist(path string) ([]*regexp.Regexp, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading secrets allowlist: %w", err)
+	}
+
+	allowlist := []*regexp.Regexp{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("secrets allowlist line %d: %w", i+1, err)
+		}
+		allowlist = append(allowlist, re)
+	}
+
+	return allowlist, nil
+}
+
+// scanSecretsFile reports the secrets in a file. A secret is ignored when a line of the allowlist matches it or
+// its file:line location, or when its rule is suppressed with a zen:ignore comment on its line or the one above.
+// The secrets reported are added to r
+func scanSecretsFile(path, rel string, allowlist []*regexp.Regexp, r *redactor) ([]finding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", rel, err)
+	}
+
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) != -1 {
+		// binary file
+		return nil, nil
+	}
+
+	findings := []finding{}
+	var previous string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+
+		suppressed := []string{}
+		for _, l := range []string{previous, line} {
+			for _, m := range suppressionRe.FindAllStringSubmatch(l, -1) {
+				suppressed = append(suppressed, strings.Split(m[1], ",")...)
+			}
+		}
+		previous = line
+
+		report := func(id, description, secret string, column int) {
+			if slices.Contains(suppressed, id) {
+				return
+			}
+
+			loc := fmt.Sprintf("%s:%d", rel, lineNo)
+			for _, re := range allowlist {
+				if re.MatchString(secret) || re.MatchString(loc) {
+					return
+				}
+			}
+
+			r.add(secret)
+			findings = append(findings, finding{
+				RuleID:  id,
+				Level:   "error",
+				Message: fmt.Sprintf("possible %s %s", description, maskSecret(secret)),
+				File:    rel,
+				Line:    lineNo,
+				Column:  column,
+			})
+		}
+
+		matched := map[string]bool{}
+		for _, sp := range secretPatterns {
+			for _, m := range sp.Re.FindAllStringSubmatchIndex(line, -1) {
+				start, end := m[len(m)-2], m[len(m)-1]
+				if matched[line[start:end]] {
+					// already reported by a more specific pattern
+					continue
+				}
+				matched[line[start:end]] = true
+				report(sp.ID, sp.Description, line[start:end], start+1)
+			}
+		}
+
+		for _, q := range quotedRe.FindAllStringSubmatchIndex(line, -1) {
+			for _, t := range entropyTokenRe.FindAllStringIndex(line[q[2]:q[3]], -1) {
+				token := line[q[2]+t[0] : q[2]+t[1]]
+				if !matched[token] && isHighEntropy(token) {
+					report(highEntropyID, "secret (high entropy string)", token, q[2]+t[0]+1)
+				}
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// scanSecrets scans the srcs, var files, data, providers, backends and local modules of the target. Paths are
+// reported relative to root, the package directory
+func scanSecrets(target *zen_targets.Target, root string) ([]finding, error) {
+	var allowlistPath string
+	if al := target.Srcs["secrets_allowlist"]; len(al) > 0 {
+		allowlistPath = al[0]
+	}
+	allowlist, err := loadSecretsAllowlist(allowlistPath)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := append([]string{}, secretsSrcs...)
+	for key := range target.Srcs {
+		if strings.HasPrefix(key, "backend") || strings.HasPrefix(key, envSrcsPrefix) {
+			groups = append(groups, key)
+		}
+	}
+
+	files := map[string]bool{}
+	for _, group := range groups {
+		for _, src := range target.Srcs[group] {
+			// encrypted var files are meant to hold secrets
+			if strings.HasSuffix(src, ".age") || filepath.Base(src) == ".terraform.lock.hcl" || isSopsFile(src) {
+				continue
+			}
+			files[src] = true
+		}
+	}
+
+	paths := []string{}
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	findings := []finding{}
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			rel = path
+		}
+
+		fileFindings, err := scanSecretsFile(path, rel, allowlist, secretsOf(target))
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, fileFindings...)
+	}
+
+	return findings, nil
+}
+
+// packageRoot returns the directory the srcs are relative to, the parent of the env directory scripts run in
+func packageRoot(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) string {
+	if runCtx.Env == "" {
+		return target.Cwd
+	}
+
+	return filepath.Dir(target.Cwd)
+}
+
+func runSecrets(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	target.SetStatus(fmt.Sprintf("Scanning %s for secrets", target.Qn()))
+	findings, err := scanSecrets(target, target.Cwd)
+	if err != nil {
+		return err
+	}
+
+	if err := writeReports(target, "secrets", "zen secrets", findings); err != nil {
+		return err
+	}
+
+	if errs := countErrors(findings); errs > 0 {
+		return findingsError(findings, "found %d possible secrets", errs)
+	}
+
+	return nil
+}
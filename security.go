@@ -0,0 +1,534 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	zen_targets "github.com/zen-io/zen-core/target"
+	"golang.org/x/exp/slices"
+)
+
+// securityResource is a resource as seen by the security rules. Values hold the known attributes, with nested
+// blocks as lists of objects, the same shape terraform uses for planned values
+type securityResource struct {
+	Addr       string
+	Type       string
+	Name       string
+	Values     map[string]interface{}
+	File       string
+	Line       int
+	Suppressed []string
+}
+
+func (sr *securityResource) Address() string {
+	if sr.Addr != "" {
+		return sr.Addr
+	}
+
+	return sr.Type + "." + sr.Name
+}
+
+type securityRule struct {
+	ID          string
+	Severity    string // CRITICAL, HIGH, MEDIUM or LOW
+	Description string
+	Check       func(r *securityResource, all []*securityResource) []string
+}
+
+var suppressionRe = regexp.MustCompile(`zen:ignore:([A-Za-z0-9_,]+)`)
+
+// parseSecurityResources reads every resource declared in the .tf and .tf.json files in dir
+func parseSecurityResources(dir string) ([]*securityResource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	resources := []*securityResource{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			continue
+		}
+
+		var parsed []*securityResource
+		if strings.HasSuffix(path, ".tf") {
+			parsed, err = parseHCLSecurityResources(path)
+		} else if strings.HasSuffix(path, ".tf.json") {
+			parsed, err = parseJSONSecurityResources(path)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, parsed...)
+	}
+
+	return resources, nil
+}
+
+func parseHCLSecurityResources(path string) ([]*securityResource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	file, diags := hclsyntax.ParseConfig(data, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("parsing %s: %s", path, diags.Error())
+	}
+
+	lines := strings.Split(string(data), "\n")
+	resources := []*securityResource{}
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "resource" || len(block.Labels) != 2 {
+			continue
+		}
+
+		// suppressions can be on the lines right above the block or anywhere inside it
+		from := block.DefRange().Start.Line - 1
+		for from > 0 && strings.HasPrefix(strings.TrimSpace(lines[from-1]), "#") {
+			from--
+		}
+		suppressed := []string{}
+		for _, line := range lines[from:block.Range().End.Line] {
+			for _, m := range suppressionRe.FindAllStringSubmatch(line, -1) {
+				suppressed = append(suppressed, strings.Split(m[1], ",")...)
+			}
+		}
+
+		resources = append(resources, &securityResource{
+			Type:       block.Labels[0],
+			Name:       block.Labels[1],
+			Values:     hclBodyValues(block.Body),
+			File:       path,
+			Line:       block.DefRange().Start.Line,
+			Suppressed: suppressed,
+		})
+	}
+
+	return resources, nil
+}
+
+// unknownValue marks attributes that are set but cannot be known before planning, with the addresses of the
+// resources they reference
+type unknownValue struct {
+	refs []string
+}
+
+// nonResourceRoots are the references that do not point to a managed resource
+var nonResourceRoots = []string{"var", "local", "data", "module", "each", "count", "path", "terraform", "self"}
+
+// resourceRefs returns the addresses of the resources an expression references
+func resourceRefs(expr hclsyntax.Expression) []string {
+	refs := []string{}
+	for _, traversal := range expr.Variables() {
+		if len(traversal) < 2 || slices.Contains(nonResourceRoots, traversal.RootName()) {
+			continue
+		}
+
+		if attr, ok := traversal[1].(hcl.TraverseAttr); ok {
+			refs = append(refs, traversal.RootName()+"."+attr.Name)
+		}
+	}
+
+	return refs
+}
+
+// hclBodyValues evaluates the attributes that do not depend on anything else. Attributes referencing
+// variables, resources or functions are unknown until planned
+func hclBodyValues(body *hclsyntax.Body) map[string]interface{} {
+	values := map[string]interface{}{}
+	for name, attr := range body.Attributes {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || !val.IsWhollyKnown() {
+			values[name] = unknownValue{refs: resourceRefs(attr.Expr)}
+			continue
+		}
+
+		data, err := ctyjson.Marshal(val, val.Type())
+		if err != nil {
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err == nil {
+			values[name] = v
+		}
+	}
+
+	for _, block := range body.Blocks {
+		list, _ := values[block.Type].([]interface{})
+		values[block.Type] = append(list, hclBodyValues(block.Body))
+	}
+
+	return values
+}
+
+func parseJSONSecurityResources(path string) ([]*securityResource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var doc struct {
+		Resource map[string]map[string]map[string]interface{} `json:"resource"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	resources := []*securityResource{}
+	for rType, byName := range doc.Resource {
+		for name, values := range byName {
+			suppressed := []string{}
+			if comment, ok := values["//"].(string); ok {
+				for _, m := range suppressionRe.FindAllStringSubmatch(comment, -1) {
+					suppressed = append(suppressed, strings.Split(m[1], ",")...)
+				}
+			}
+
+			resources = append(resources, &securityResource{
+				Type:       rType,
+				Name:       name,
+				Values:     values,
+				File:       path,
+				Suppressed: suppressed,
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// mergePlanResources replaces the statically known values with the planned ones, keeping the source
+// location and suppressions of the matching declaration
+func mergePlanResources(declared []*securityResource, plan *planJSON) []*securityResource {
+	byAddress := map[string]*securityResource{}
+	for _, r := range declared {
+		byAddress[r.Address()] = r
+	}
+
+	merged := []*securityResource{}
+	for _, pr := range plan.PlannedValues.RootModule.allResources() {
+		if pr.Mode != "managed" {
+			continue
+		}
+
+		r := &securityResource{Addr: pr.Address, Type: pr.Type, Name: pr.Name, Values: pr.Values}
+		if decl, ok := byAddress[pr.Type+"."+pr.Name]; ok && !strings.HasPrefix(pr.Address, "module.") {
+			r.File = decl.File
+			r.Line = decl.Line
+			r.Suppressed = decl.Suppressed
+
+			// values only known after apply are missing from the plan, the declaration still has their references
+			values := map[string]interface{}{}
+			for k, v := range pr.Values {
+				values[k] = v
+			}
+			for k, v := range decl.Values {
+				if _, planned := values[k]; !planned {
+					if _, ok := v.(unknownValue); ok {
+						values[k] = v
+					}
+				}
+			}
+			r.Values = values
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+func valueString(values map[string]interface{}, key string) string {
+	s, _ := values[key].(string)
+	return s
+}
+
+// valueFalse is true only when the value is known to be false
+func valueFalse(values map[string]interface{}, key string) bool {
+	b, ok := values[key].(bool)
+	return ok && !b
+}
+
+// valueNotTrue is true when the value is known not to be true, or missing when it defaults to false
+func valueNotTrue(values map[string]interface{}, key string) bool {
+	v, ok := values[key]
+	if !ok || v == nil {
+		return true
+	}
+
+	b, ok := v.(bool)
+	return ok && !b
+}
+
+func valueBlocks(values map[string]interface{}, key string) []map[string]interface{} {
+	blocks := []map[string]interface{}{}
+	list, _ := values[key].([]interface{})
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			blocks = append(blocks, m)
+		}
+	}
+
+	return blocks
+}
+
+func valueStrings(values map[string]interface{}, key string) []string {
+	strs := []string{}
+	list, _ := values[key].([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+
+	return strs
+}
+
+func isOpenCidr(cidr string) bool {
+	return cidr == "0.0.0.0/0" || cidr == "::/0"
+}
+
+var securityRules = []securityRule{
+	{
+		ID:          "ZEN_TF_001",
+		Severity:    "CRITICAL",
+		Description: "Storage buckets must not be public",
+		Check: func(r *securityResource, _ []*securityResource) []string {
+			switch r.Type {
+			case "aws_s3_bucket", "aws_s3_bucket_acl":
+				if acl := valueString(r.Values, "acl"); acl == "public-read" || acl == "public-read-write" {
+					return []string{fmt.Sprintf("bucket acl is %s", acl)}
+				}
+			case "aws_s3_bucket_public_access_block":
+				msgs := []string{}
+				for _, key := range []string{"block_public_acls", "block_public_policy", "ignore_public_acls", "restrict_public_buckets"} {
+					if valueNotTrue(r.Values, key) {
+						msgs = append(msgs, fmt.Sprintf("%s is not enabled", key))
+					}
+				}
+				return msgs
+			case "google_storage_bucket_iam_member", "google_storage_bucket_iam_binding":
+				members := append(valueStrings(r.Values, "members"), valueString(r.Values, "member"))
+				for _, m := range members {
+					if m == "allUsers" || m == "allAuthenticatedUsers" {
+						return []string{fmt.Sprintf("bucket is readable by %s", m)}
+					}
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		ID:          "ZEN_TF_002",
+		Severity:    "HIGH",
+		Description: "Ingress must not be open to the whole internet",
+		Check: func(r *securityResource, _ []*securityResource) []string {
+			msgs := []string{}
+			switch r.Type {
+			case "aws_security_group":
+				for _, ingress := range valueBlocks(r.Values, "ingress") {
+					for _, cidr := range append(valueStrings(ingress, "cidr_blocks"), valueStrings(ingress, "ipv6_cidr_blocks")...) {
+						if isOpenCidr(cidr) {
+							msgs = append(msgs, fmt.Sprintf("ingress allows %s", cidr))
+						}
+					}
+				}
+			case "aws_security_group_rule":
+				if valueString(r.Values, "type") == "ingress" {
+					for _, cidr := range append(valueStrings(r.Values, "cidr_blocks"), valueStrings(r.Values, "ipv6_cidr_blocks")...) {
+						if isOpenCidr(cidr) {
+							msgs = append(msgs, fmt.Sprintf("ingress allows %s", cidr))
+						}
+					}
+				}
+			case "aws_vpc_security_group_ingress_rule":
+				for _, key := range []string{"cidr_ipv4", "cidr_ipv6"} {
+					if cidr := valueString(r.Values, key); isOpenCidr(cidr) {
+						msgs = append(msgs, fmt.Sprintf("ingress allows %s", cidr))
+					}
+				}
+			case "google_compute_firewall":
+				if dir := valueString(r.Values, "direction"); dir == "" || dir == "INGRESS" {
+					for _, cidr := range valueStrings(r.Values, "source_ranges") {
+						if isOpenCidr(cidr) {
+							msgs = append(msgs, fmt.Sprintf("ingress allows %s", cidr))
+						}
+					}
+				}
+			}
+
+			return msgs
+		},
+	},
+	{
+		ID:          "ZEN_TF_003",
+		Severity:    "HIGH",
+		Description: "Storage must be encrypted at rest",
+		Check: func(r *securityResource, _ []*securityResource) []string {
+			var key string
+			switch r.Type {
+			case "aws_ebs_volume", "aws_efs_file_system":
+				key = "encrypted"
+			case "aws_db_instance", "aws_rds_cluster", "aws_docdb_cluster", "aws_neptune_cluster":
+				key = "storage_encrypted"
+			case "aws_redshift_cluster":
+				if valueFalse(r.Values, "encrypted") {
+					return []string{"encrypted is disabled"}
+				}
+				return nil
+			default:
+				return nil
+			}
+
+			if valueNotTrue(r.Values, key) {
+				return []string{fmt.Sprintf("%s is not enabled", key)}
+			}
+
+			return nil
+		},
+	},
+	{
+		ID:          "ZEN_TF_004",
+		Severity:    "MEDIUM",
+		Description: "Access logging must be enabled",
+		Check: func(r *securityResource, all []*securityResource) []string {
+			switch r.Type {
+			case "aws_s3_bucket":
+				if len(valueBlocks(r.Values, "logging")) > 0 {
+					return nil
+				}
+				for _, other := range all {
+					if other.Type == "aws_s3_bucket_logging" && referencesBucket(other.Values["bucket"], r) {
+						return nil
+					}
+				}
+				return []string{"bucket has no access logging"}
+			case "aws_lb", "aws_alb":
+				logs := valueBlocks(r.Values, "access_logs")
+				if len(logs) == 0 || valueNotTrue(logs[0], "enabled") {
+					return []string{"load balancer has no access logs"}
+				}
+			case "aws_cloudfront_distribution":
+				if len(valueBlocks(r.Values, "logging_config")) == 0 {
+					return []string{"distribution has no logging_config"}
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// referencesBucket tells whether a bucket attribute is the name of the bucket, or a reference to it
+func referencesBucket(val interface{}, bucket *securityResource) bool {
+	switch v := val.(type) {
+	case string:
+		return v != "" && v == valueString(bucket.Values, "bucket")
+	case unknownValue:
+		return slices.Contains(v.refs, bucket.Type+"."+bucket.Name)
+	}
+
+	return false
+}
+
+// severityLevel maps a rule severity to a report level
+func severityLevel(severity string) string {
+	switch severity {
+	case "CRITICAL", "HIGH":
+		return "error"
+	case "MEDIUM":
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func evaluateSecurityRules(resources []*securityResource, relPath func(string) string) []finding {
+	findings := []finding{}
+	for _, r := range resources {
+		for _, rule := range securityRules {
+			if slices.Contains(r.Suppressed, rule.ID) {
+				continue
+			}
+
+			for _, msg := range rule.Check(r, resources) {
+				findings = append(findings, finding{
+					RuleID:  rule.ID,
+					Level:   severityLevel(rule.Severity),
+					Message: fmt.Sprintf("%s: %s: %s (%s)", r.Address(), rule.Description, msg, rule.Severity),
+					File:    relPath(r.File),
+					Line:    r.Line,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+
+	return findings
+}
+
+func runSecurity(usePlan func(env string) bool, initArgs, planArgs func(env string) []string) func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	return func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+		target.SetStatus(fmt.Sprintf("Checking security of %s", target.Qn()))
+		resources, err := parseSecurityResources(target.Cwd)
+		if err != nil {
+			return fmt.Errorf("checking security: %w", err)
+		}
+
+		if usePlan(runCtx.Env) {
+			target.SetStatus(fmt.Sprintf("Planning %s", target.Qn()))
+			if err := tfInit(target, runCtx.Env, initArgs(runCtx.Env)...); err != nil {
+				return fmt.Errorf("checking security: %w", err)
+			}
+
+			plan, _, err := tfShowPlan(target, runCtx.Env, planArgs(runCtx.Env)...)
+			os.Remove(filepath.Join(target.Cwd, planFile))
+			if err != nil {
+				return fmt.Errorf("checking security: %w", err)
+			}
+			resources = mergePlanResources(resources, plan)
+		}
+
+		sm := loadSourceMap(target.Cwd)
+		findings := evaluateSecurityRules(resources, func(file string) string {
+			if file == "" {
+				return ""
+			}
+			rel, err := filepath.Rel(target.Cwd, file)
+			if err != nil {
+				return file
+			}
+			return sm.resolve(rel)
+		})
+
+		if err := writeReports(target, "security", "zen terraform security", findings); err != nil {
+			return err
+		}
+
+		if errs := countErrors(findings); errs > 0 {
+			return findingsError(findings, "found %d security issues", errs)
+		}
+
+		return nil
+	}
+}
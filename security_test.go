@@ -0,0 +1,63 @@
+package terraform
+
+import (
+	"path/filepath"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestSecurityBucketLogging(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.tf": `resource "aws_s3_bucket" "logged" {}
+
+resource "aws_s3_bucket" "named" {
+  bucket = "named-bucket"
+}
+
+resource "aws_s3_bucket" "unlogged" {}
+
+resource "aws_s3_bucket_logging" "logged" {
+  bucket        = aws_s3_bucket.logged.id
+  target_bucket = aws_s3_bucket.unlogged.id
+}
+
+resource "aws_s3_bucket_logging" "named" {
+  bucket        = "named-bucket"
+  target_bucket = "logs"
+}
+`,
+	})
+
+	resources, err := parseSecurityResources(dir)
+	assert.NilError(t, err)
+
+	got := []string{}
+	for _, f := range evaluateSecurityRules(resources, filepath.Base) {
+		if f.RuleID == "ZEN_TF_004" {
+			got = append(got, f.Message)
+		}
+	}
+	assert.DeepEqual(t, got, []string{"aws_s3_bucket.unlogged: Access logging must be enabled: bucket has no access logging (MEDIUM)"})
+}
+
+func TestSecurityScript(t *testing.T) {
+	fe := useFakeExecutor(t)
+	fe.respond(t, "show", `{"planned_values":{"root_module":{"resources":[{"address":"aws_ebs_volume.a","mode":"managed","type":"aws_ebs_volume","name":"a","values":{"encrypted":false}}]}}}`, 0)
+	reconfigure, securityPlan := true, true
+	tc := testConfig("dev")
+	tc.SecurityPlan = &securityPlan
+	tc.CliOptions = &CliOptions{Init: &CommandOptions{Reconfigure: &reconfigure}}
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{"main.tf": "resource \"aws_ebs_volume\" \"a\" {\n  encrypted = var.encrypted\n}\n"})
+
+	err := runScript(t, tb, root, "security", &zen_targets.RuntimeContext{Env: "dev"})
+	assert.ErrorContains(t, err, "aws_ebs_volume.a: Storage must be encrypted at rest: encrypted is not enabled")
+	assert.DeepEqual(t, fe.commands(t), []string{
+		"terraform init -reconfigure",
+		"terraform plan -out=zen.tfplan -json",
+		"terraform show -json zen.tfplan",
+	})
+}
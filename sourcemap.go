@@ -0,0 +1,74 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceMapFile is written by build into every env directory, mapping the flattened files back to their sources
+const sourceMapFile = ".zen_sourcemap.json"
+
+// sourceMap maps a path relative to the env directory to the original source path
+type sourceMap map[string]string
+
+func (sm sourceMap) add(dest, to, original string) {
+	if rel, err := filepath.Rel(dest, to); err == nil {
+		sm[rel] = original
+	}
+}
+
+// resolve returns the original path for filename, matching whole files first and then the directories
+// modules were linked into
+func (sm sourceMap) resolve(filename string) string {
+	filename = filepath.Clean(filename)
+	if orig, ok := sm[filename]; ok {
+		return orig
+	}
+
+	for dir := filepath.Dir(filename); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if orig, ok := sm[dir]; ok {
+			return filepath.Join(orig, strings.TrimPrefix(filename, dir+string(filepath.Separator)))
+		}
+	}
+
+	return filename
+}
+
+func (sm sourceMap) rewriteDiagnostic(diag *uiDiagnostic) {
+	if sm == nil || diag == nil || diag.Range == nil {
+		return
+	}
+
+	diag.Range.Filename = sm.resolve(diag.Range.Filename)
+}
+
+func (sm sourceMap) save(dir string) error {
+	data, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding source map: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, sourceMapFile), data, 0644); err != nil {
+		return fmt.Errorf("writing source map: %w", err)
+	}
+
+	return nil
+}
+
+// loadSourceMap reads the source map in dir. A missing or unreadable map results in paths being left untouched
+func loadSourceMap(dir string) sourceMap {
+	data, err := os.ReadFile(filepath.Join(dir, sourceMapFile))
+	if err != nil {
+		return nil
+	}
+
+	sm := sourceMap{}
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return nil
+	}
+
+	return sm
+}
@@ -0,0 +1,196 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	environs "github.com/zen-io/zen-core/environments"
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+const (
+	// defaultTagsFile is generated by build in every env directory when default tags are enabled
+	defaultTagsFile = "_zen_default_tags_override.tf"
+	// defaultTagsProviderFile configures the provider with the default tags when the srcs do not configure it
+	defaultTagsProviderFile = "_zen_default_tags.tf"
+)
+
+// tagVariablePrefix marks the environment variables that become default tags, e.g. TERRAFORM_TAG_owner
+const tagVariablePrefix = "TERRAFORM_TAG_"
+
+// envVariablesWithPrefix collects the variables starting with prefix from the project and target
+// environments, the target taking precedence. The prefix is removed from the returned keys
+func envVariablesWithPrefix(tcc *zen_targets.TargetConfigContext, env string, envConf *environs.Environment, prefix string) map[string]string {
+	vars := map[string]string{}
+	for _, e := range []*environs.Environment{tcc.Environments[env], envConf} {
+		if e == nil {
+			continue
+		}
+
+		for k, v := range variablesWithPrefix(e.Variables, prefix) {
+			vars[k] = v
+		}
+	}
+
+	return vars
+}
+
+// variablesWithPrefix returns the variables starting with prefix, without it
+func variablesWithPrefix(variables map[string]string, prefix string) map[string]string {
+	vars := map[string]string{}
+	for k, v := range variables {
+		if strings.HasPrefix(k, prefix) {
+			vars[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+
+	return vars
+}
+
+func isOverrideFile(name string) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".tf")
+	return name == "override" || strings.HasSuffix(name, "_override")
+}
+
+// hasBaseProvider tells whether the files in dir configure the default, unaliased, provider outside of override
+// files. Terraform fails to load an override of a provider block that does not exist
+func hasBaseProvider(dir, provider string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	parser := hclparse.NewParser()
+	for _, entry := range entries {
+		if entry.IsDir() || !isTfFile(entry.Name()) || isOverrideFile(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(path, ".tf") {
+			file, diags = parser.ParseHCLFile(path)
+		} else {
+			file, diags = parser.ParseJSONFile(path)
+		}
+		if diags.HasErrors() {
+			return false, fmt.Errorf("parsing %s: %s", path, diags.Error())
+		}
+
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "provider", LabelNames: []string{"name"}}},
+		})
+		for _, block := range content.Blocks {
+			if block.Labels[0] != provider {
+				continue
+			}
+
+			attrs, _, _ := block.Body.PartialContent(&hcl.BodySchema{Attributes: []hcl.AttributeSchema{{Name: "alias"}}})
+			if _, aliased := attrs.Attributes["alias"]; !aliased {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// writeDefaultTags adds the tags as default_tags of the provider, overriding its block in the srcs. When the srcs
+// do not configure the provider, the generated block configures it
+func writeDefaultTags(dest, provider string, tags map[string]string) error {
+	ctyTags := map[string]cty.Value{}
+	for k, v := range tags {
+		ctyTags[k] = cty.StringVal(v)
+	}
+
+	f := hclwrite.NewEmptyFile()
+	p := f.Body().AppendNewBlock("provider", []string{provider})
+	p.Body().AppendNewBlock("default_tags", nil).Body().SetAttributeValue("tags", cty.MapVal(ctyTags))
+
+	base, err := hasBaseProvider(dest, provider)
+	if err != nil {
+		return fmt.Errorf("writing default tags: %w", err)
+	}
+
+	name := defaultTagsFile
+	if !base {
+		name = defaultTagsProviderFile
+	}
+
+	if err := os.WriteFile(filepath.Join(dest, name), f.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing default tags: %w", err)
+	}
+
+	return nil
+}
+
+// resourceTags returns the tags terraform will apply to a planned resource, including the provider defaults.
+// taggable is false for resources without tags, and known is false when they are only known after apply
+func resourceTags(rc planResourceChange) (tags map[string]interface{}, taggable, known bool) {
+	for _, key := range []string{"tags_all", "tags", "labels"} {
+		value, exists, isKnown := lookupAttribute(rc.Change.After, rc.Change.AfterUnknown, key)
+		if !isKnown {
+			return nil, true, false
+		}
+
+		after, _ := rc.Change.After.(map[string]interface{})
+		if _, declared := after[key]; !declared {
+			continue
+		}
+
+		tags, _ = value.(map[string]interface{})
+		if !exists || tags == nil {
+			tags = map[string]interface{}{}
+		}
+
+		return tags, true, true
+	}
+
+	return nil, false, true
+}
+
+// checkRequiredTags reports every created or updated resource missing one of the required tags
+func checkRequiredTags(plan *planJSON, required []string) []finding {
+	findings := []finding{}
+	if len(required) == 0 {
+		return findings
+	}
+
+	rule := policyRule{ID: "required-tags"}
+	for _, rc := range plan.ResourceChanges {
+		if !rule.appliesTo(rc) {
+			continue
+		}
+
+		tags, taggable, known := resourceTags(rc)
+		if !taggable || !known {
+			continue
+		}
+
+		missing := []string{}
+		for _, tag := range required {
+			if v, ok := tags[tag]; !ok || v == nil || v == "" {
+				missing = append(missing, tag)
+			}
+		}
+		sort.Strings(missing)
+
+		if len(missing) > 0 {
+			findings = append(findings, finding{
+				RuleID:  "required-tags",
+				Level:   "error",
+				Message: fmt.Sprintf("%s is missing the required tags %s", rc.Address, strings.Join(missing, ", ")),
+			})
+		}
+	}
+
+	return findings
+}
@@ -0,0 +1,53 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+	"gotest.tools/v3/assert"
+)
+
+func TestBuildDefaultTags(t *testing.T) {
+	tc := testConfig("dev", "prod")
+	tc.DefaultTags = true
+	tc.EnvSrcs = map[string][]string{"prod": {"prod/*.tf"}}
+	tc.Environments["dev"].Variables["TERRAFORM_TAG_team"] = "infra"
+	tc.Environments["prod"].Variables["TERRAFORM_TAG_team"] = "infra"
+	tb := getTarget(t, tc)
+	root := buildProject(t, tb, map[string]string{
+		"main.tf":          "resource \"aws_s3_bucket\" \"a\" {}\n",
+		"prod/provider.tf": "provider \"aws\" {\n  region = \"eu-west-1\"\n}\n\nprovider \"aws\" {\n  alias  = \"us\"\n  region = \"us-east-1\"\n}\n",
+	})
+
+	// without a provider block in the srcs the generated one configures it, overriding it would fail to load
+	_, err := os.Stat(filepath.Join(root, "dev", defaultTagsFile))
+	assert.Assert(t, os.IsNotExist(err))
+	data, err := os.ReadFile(filepath.Join(root, "dev", defaultTagsProviderFile))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), `team = "infra"`), string(data))
+
+	_, err = os.Stat(filepath.Join(root, "prod", defaultTagsFile))
+	assert.NilError(t, err)
+}
+
+func TestBuildDefaultTagsWithoutEnvironments(t *testing.T) {
+	tc := testConfig()
+	tc.Environments = nil
+	tc.DefaultTags = true
+	tb, err := tc.GetTargets(&zen_targets.TargetConfigContext{
+		KnownToolchains: map[string]string{"terraform": "terraform", "tflocal": "tflocal", "tflint": "tflint"},
+		Variables:       map[string]string{"TERRAFORM_TAG_team": "infra"},
+	})
+	assert.NilError(t, err)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.tf": "provider \"aws\" {}\n"})
+	assert.NilError(t, runScript(t, tb[0], root, "build", &zen_targets.RuntimeContext{}))
+
+	data, err := os.ReadFile(filepath.Join(root, defaultTagsFile))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(data), `team = "infra"`), string(data))
+}
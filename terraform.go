@@ -2,9 +2,10 @@ package terraform
 
 import (
 	"fmt"
-	"os/exec"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	environs "github.com/zen-io/zen-core/environments"
@@ -14,14 +15,26 @@ import (
 )
 
 type TerraformDeploymentConfig struct {
-	VarFiles        []string `mapstructure:"var_files" desc:"Variable files to include (.tfvars)"`
-	Backend         *string  `mapstructure:"backend" desc:"Terraform backend file. Can be a ref or path"`
-	Terraform       *string  `mapstructure:"terraform" desc:"Terraform executable. Can be a ref or path"`
-	Tflocal         *string  `mapstructure:"tflocal" desc:"Tflocal executable. Can be a ref or path"`
-	Tflint          *string  `mapstructure:"tflint" desc:"Tflint executable. Can be a ref or path"`
-	Modules         []string `mapstructure:"modules" desc:"Modules to include as sources. Can have references"`
-	ProviderConfigs []string `mapstructure:"provider_configs" desc:"Providers to include as sources"`
-	AllowFailure    bool     `mapstructure:"allow_failure"`
+	VarFiles        []string          `mapstructure:"var_files" desc:"Variable files to include (.tfvars), later files take precedence"`
+	Backend         *string           `mapstructure:"backend" desc:"Terraform backend file. Can be a ref or path"`
+	BackendKey      string            `mapstructure:"backend_key" desc:"State key interpolated as {BACKEND_KEY}"`
+	Terraform       *string           `mapstructure:"terraform" desc:"Terraform executable. Can be a ref or path"`
+	Tflocal         *string           `mapstructure:"tflocal" desc:"Tflocal executable. Can be a ref or path"`
+	Tflint          *string           `mapstructure:"tflint" desc:"Tflint executable. Can be a ref or path"`
+	Modules         []string          `mapstructure:"modules" desc:"Modules to include as sources. Can have references"`
+	ProviderConfigs []string          `mapstructure:"provider_configs" desc:"Providers to include as sources"`
+	AllowFailure    *bool             `mapstructure:"allow_failure"`
+	StateMoves      map[string]string `mapstructure:"state_moves" desc:"State addresses to move, old to new"`
+	StateRemoves    []string          `mapstructure:"state_removes" desc:"State addresses to stop tracking"`
+	Targets         []string          `mapstructure:"targets" desc:"Resource addresses to limit deploy to"`
+	Replace         []string          `mapstructure:"replace" desc:"Resource addresses to replace on deploy"`
+	ForceTargeted   *bool             `mapstructure:"force_targeted" desc:"Allow targeted deploys on protected environments"`
+	AgeKeyEnv       string            `mapstructure:"age_key_env" desc:"Environment variable holding the age identities. Defaults to SOPS_AGE_KEY"`
+	SecretAllowlist *string           `mapstructure:"secrets_allowlist" desc:"Patterns the secrets scan ignores. Can be a ref or path"`
+	TflintConfig    *string           `mapstructure:"tflint_config" desc:"Tflint config file. Can be a ref or path"`
+	TflintPluginDir string            `mapstructure:"tflint_plugin_dir" desc:"Tflint plugin directory"`
+	SecurityPlan    *bool             `mapstructure:"security_plan" desc:"Also check the security rules against the plan"`
+	CliOptions      *CliOptions       `mapstructure:"cli_options" desc:"Options passed to the terraform commands"`
 }
 
 type DeployConfig struct {
@@ -43,9 +56,20 @@ type TerraformConfig struct {
 	Tools                     map[string]string                `mapstructure:"tools" zen:"yes" desc:"Key-Value map of tools to include when executing this target. Values can be references"`
 	Visibility                []string                         `mapstructure:"visibility" zen:"yes" desc:"List of visibility for this target"`
 	Environments              map[string]*environs.Environment `mapstructure:"environments" zen:"yes" desc:"Deployment Environments"`
+	Matrix                    map[string][]string              `mapstructure:"matrix" desc:"Axes to expand every environment over"`
 	Deploy                    *DeployConfig                    `mapstructure:"deploy"`
+	EnvOverrides              map[string]*EnvOverride          `mapstructure:"env_overrides" desc:"Per environment deployment settings"`
 	Srcs                      []string                         `mapstructure:"srcs" desc:"Terraform source files (.tf)"`
+	EnvSrcs                   map[string][]string              `mapstructure:"env_srcs" desc:"Per environment terraform source files"`
 	Data                      []string                         `mapstructure:"data" desc:"Other files to add to this execution, that wont be interpolated"`
+	Interpolation             string                           `mapstructure:"interpolation" desc:"Interpolation syntax, zen or hcl. Defaults to zen"`
+	InterpolateSrcs           bool                             `mapstructure:"interpolate_srcs" desc:"Also interpolate srcs"`
+	Tests                     []string                         `mapstructure:"tests" desc:"Terraform test files (.tftest.hcl)"`
+	Vars                      map[string]interface{}           `mapstructure:"vars" desc:"Terraform variables for every environment"`
+	Policies                  []string                         `mapstructure:"policies" desc:"Policy files checked against the plan. Can have references"`
+	RequiredTags              []string                         `mapstructure:"required_tags" desc:"Tags every planned resource must have"`
+	DefaultTags               bool                             `mapstructure:"default_tags" desc:"Generate the provider default_tags"`
+	DefaultTagsProvider       string                           `mapstructure:"default_tags_provider" desc:"Provider that gets the default_tags. Defaults to aws"`
 	TerraformDeploymentConfig `mapstructure:",squash"`
 }
 
@@ -55,12 +79,89 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 		"_data":     tc.Data,
 		"providers": {},
 		"modules":   {},
+		"tests":     tc.Tests,
+	}
+
+	if err := validInterpolation(tc.Interpolation); err != nil {
+		return nil, err
+	}
+
+	if err := tc.CliOptions.validate(); err != nil {
+		return nil, fmt.Errorf("cli_options: %w", err)
+	}
+
+	for env, o := range tc.EnvOverrides {
+		if o == nil {
+			continue
+		}
+
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("env_overrides %s: %w", env, err)
+		}
+	}
+
+	baseEnvs, err := tc.expandMatrix(tcc)
+	if err != nil {
+		return nil, err
+	}
+
+	// override files can be scoped to an environment, or to all the combinations of a base one
+	scopes := map[string]bool{}
+	for env := range tc.Environments {
+		scopes[env] = true
+		if base, ok := baseEnvs[env]; ok {
+			scopes[base] = true
+		}
+	}
+
+	srcsEnvs := []string{}
+	for env := range tc.EnvSrcs {
+		if _, ok := tc.Environments[env]; !ok {
+			return nil, fmt.Errorf("env_srcs: unknown environment %s", env)
+		}
+		srcsEnvs = append(srcsEnvs, env)
+	}
+	sort.Strings(srcsEnvs)
+
+	for _, env := range srcsEnvs {
+		buildSrcs[envSrcsPrefix+env] = tc.EnvSrcs[env]
+		for _, src := range tc.EnvSrcs[env] {
+			if zen_targets.IsTargetReference(src) {
+				tc.Deps = append(tc.Deps, src)
+			}
+		}
+	}
+
+	overrideEnvs := []string{}
+	for env, o := range tc.EnvOverrides {
+		if _, ok := tc.Environments[env]; !ok {
+			return nil, fmt.Errorf("env_overrides: unknown environment %s", env)
+		} else if o != nil {
+			overrideEnvs = append(overrideEnvs, env)
+		}
+	}
+	sort.Strings(overrideEnvs)
+
+	// the env of an override is set like the environment variables, for zen to pass it to the scripts of that env only
+	if len(overrideEnvs) > 0 {
+		environments := map[string]*environs.Environment{}
+		for env, envConf := range tc.Environments {
+			if o := tc.EnvOverrides[env]; o != nil && o.Deploy != nil && len(o.Deploy.Env) > 0 {
+				merged := environs.Environment{}
+				if envConf != nil {
+					merged = *envConf
+				}
+				merged.Variables = utils.MergeMaps(merged.Variables, o.Deploy.Env)
+				envConf = &merged
+			}
+			environments[env] = envConf
+		}
+		tc.Environments = environments
 	}
 
 	if len(tc.Tools) == 0 {
 		tc.Tools = map[string]string{}
 	}
-	var err error
 	tc.Tools["terraform"], err = tcc.ResolveToolchain(tc.Terraform, "terraform", tc.Tools)
 	if err != nil {
 		return nil, err
@@ -92,18 +193,96 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 		tc.Labels = append(tc.Labels, fmt.Sprintf("module=%s=%s", mod, filepath.Base(mod)))
 	}
 
+	buildSrcs["policies"] = tc.Policies
+	for _, p := range tc.Policies {
+		if zen_targets.IsTargetReference(p) {
+			tc.Deps = append(tc.Deps, p)
+		}
+	}
+
+	if tc.SecretAllowlist != nil {
+		buildSrcs["secrets_allowlist"] = []string{*tc.SecretAllowlist}
+		if zen_targets.IsTargetReference(*tc.SecretAllowlist) {
+			tc.Deps = append(tc.Deps, *tc.SecretAllowlist)
+		}
+	}
+
+	if tc.TflintConfig != nil {
+		buildSrcs["tflint_config"] = []string{*tc.TflintConfig}
+		if zen_targets.IsTargetReference(*tc.TflintConfig) {
+			tc.Deps = append(tc.Deps, *tc.TflintConfig)
+		}
+	}
+
 	var outs []string
+	protectedEnvs := map[string]bool{}
+	requiredTags := map[string][]string{"": tc.RequiredTags}
+	defaultTags := map[string]map[string]string{}
+	vars := map[string]map[string]interface{}{"": tc.Vars}
+	envVariables := map[string]map[string]string{}
+	backendKeys := map[string]string{"": tc.BackendKey}
+	deployConfigs := map[string]*TerraformDeploymentConfig{}
+	envModules := map[string][]string{}
 	if tc.Environments != nil && len(tc.Environments) > 0 {
 		for env, envConf := range tc.Environments {
+			dc := tc.TerraformDeploymentConfig
+			if o := tc.EnvOverrides[env]; o != nil {
+				dc.Merge(&o.TerraformDeploymentConfig)
+
+				for _, pc := range o.ProviderConfigs {
+					buildSrcs["providers_"+env] = append(buildSrcs["providers_"+env], pc)
+					if zen_targets.IsTargetReference(pc) {
+						tc.Deps = append(tc.Deps, pc)
+					}
+				}
+
+				for _, mod := range o.Modules {
+					if zen_targets.IsTargetReference(mod) {
+						tc.Deps = append(tc.Deps, mod)
+					} else {
+						buildSrcs["modules"] = append(buildSrcs["modules"], fmt.Sprintf("%s/**", mod))
+					}
+				}
+				envModules[env] = o.Modules
+
+				if o.TflintConfig != nil {
+					buildSrcs["tflint_config_"+env] = []string{*o.TflintConfig}
+					if zen_targets.IsTargetReference(*o.TflintConfig) {
+						tc.Deps = append(tc.Deps, *o.TflintConfig)
+					}
+				}
+			}
+			deployConfigs[env] = &dc
+
+			vars[env] = mergeVars(tc.Vars, envVariablesWithPrefix(tcc, env, envConf, varVariablePrefix))
+			envVariables[env] = envVariablesWithPrefix(tcc, env, envConf, "")
+
+			backendKeys[env] = tc.BackendKey
+			if val, ok := lookupEnvVariable(tcc, env, envConf, "TERRAFORM_BACKEND_KEY"); ok {
+				backendKeys[env] = val
+			}
+			if o := tc.EnvOverrides[env]; o != nil && o.BackendKey != "" {
+				backendKeys[env] = o.BackendKey
+			}
+
+			requiredTags[env] = tc.RequiredTags
+			if val, ok := lookupEnvVariable(tcc, env, envConf, "TERRAFORM_REQUIRED_TAGS"); ok {
+				requiredTags[env] = append(append([]string{}, tc.RequiredTags...), splitList(val)...)
+			}
+
+			if tc.DefaultTags {
+				defaultTags[env] = envVariablesWithPrefix(tcc, env, envConf, tagVariablePrefix)
+			}
+
+			if val, ok := lookupEnvVariable(tcc, env, envConf, "TERRAFORM_PROTECTED"); ok && val == "true" {
+				protectedEnvs[env] = true
+			}
+
 			var backend string
-			if tc.Backend != nil {
-				backend = *tc.Backend
-			} else if val, ok := envConf.Variables["TERRAFORM_BACKEND"]; ok {
+			if dc.Backend != nil {
+				backend = *dc.Backend
+			} else if val, ok := lookupEnvVariable(tcc, env, envConf, "TERRAFORM_BACKEND"); ok {
 				backend = val
-			} else if e, ok := tcc.Environments[env]; ok && e.Variables != nil {
-				if val, ok := e.Variables["TERRAFORM_BACKEND"]; ok {
-					backend = val
-				}
 			}
 			if backend != "" {
 				if zen_targets.IsTargetReference(backend) {
@@ -116,6 +295,14 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 	} else {
 		outs = []string{"**"}
 
+		if val, ok := tcc.Variables["TERRAFORM_BACKEND_KEY"]; ok {
+			backendKeys[""] = val
+		}
+
+		if tc.DefaultTags {
+			defaultTags[""] = variablesWithPrefix(tcc.Variables, tagVariablePrefix)
+		}
+
 		if tc.Backend != nil {
 			buildSrcs["backend"] = []string{*tc.Backend}
 		} else if val, ok := tcc.Variables["TERRAFORM_BACKEND"]; ok {
@@ -126,6 +313,40 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 		}
 	}
 
+	// the environment variables terraform runs with, to tell which variables are set through TF_VAR_<name>
+	deployEnvNames := append(append([]string{}, tc.PassEnv...), tc.PassSecretEnv...)
+	for k := range tc.Env {
+		deployEnvNames = append(deployEnvNames, k)
+	}
+	if tc.Deploy != nil {
+		deployEnvNames = append(append(deployEnvNames, tc.Deploy.PassEnv...), tc.Deploy.SecretEnv...)
+		for k := range tc.Deploy.Env {
+			deployEnvNames = append(deployEnvNames, k)
+		}
+	}
+	for _, env := range overrideEnvs {
+		if o := tc.EnvOverrides[env]; o.Deploy != nil {
+			for k := range o.Deploy.Env {
+				deployEnvNames = append(deployEnvNames, k)
+			}
+		}
+	}
+
+	deployConfig := func(env string) *TerraformDeploymentConfig {
+		if dc, ok := deployConfigs[env]; ok {
+			return dc
+		}
+		return &tc.TerraformDeploymentConfig
+	}
+
+	cliOpts := func(env string) *CliOptions {
+		return MergeCliOptions(deployConfig(env).CliOptions)
+	}
+
+	ageKeyEnv := func(env string) string {
+		return deployConfig(env).AgeKeyEnv
+	}
+
 	t := zen_targets.ToTarget(tc)
 	t.Srcs = buildSrcs
 	t.Outs = outs
@@ -142,85 +363,218 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 				} else {
 					envs = append(envs, "")
 				}
+				sort.Strings(envs)
+
+				revision, err := gitRevision(target)
+				if err != nil {
+					target.Debugln(fmt.Sprintf("GIT_REVISION will not be interpolated: %s", err))
+				}
 
+				// environments sharing a state would overwrite each other's resources
+				backendEnvs := map[string]string{}
 				for _, env := range envs {
 					var dest, backendPath string
-					envInterpolate := make(map[string]string)
 					if env != "" {
 						dest = filepath.Join(target.Cwd, env)
-						envInterpolate["DEPLOY_ENV"] = env
 						backendPath = "backend_" + env
 					} else {
 						dest = target.Cwd
 						backendPath = "backend"
 					}
 
-					varFilesFilter := []string{}
-					for _, v := range tc.VarFiles {
-						interpolatedVarName, err := target.Interpolate(v, envInterpolate)
-						if err != nil {
-							return fmt.Errorf("interpolating var file name: %w", err)
+					envInterpolate, err := interpolationContext(target, env, envVariables[env], backendKeys[env], revision)
+					if err != nil {
+						return envError(env, err)
+					}
+
+					if key := envInterpolate["BACKEND_KEY"]; len(target.Srcs[backendPath]) > 0 {
+						if other, ok := backendEnvs[key]; ok {
+							return fmt.Errorf("environments %s and %s have the same BACKEND_KEY %s", other, env, key)
+						}
+						backendEnvs[key] = env
+					}
+
+					// without environments build runs in the sandbox the srcs were placed in. The ones build places
+					// under another name are moved, so that terraform does not load them twice
+					placed := []string{}
+					place := func(from, to string) {
+						if env == "" && filepath.Dir(from) == dest && from != to {
+							placed = append(placed, from)
 						}
-						varFilesFilter = append(varFilesFilter, interpolatedVarName)
 					}
 
-					for _, src := range target.Srcs["_srcs"] {
-						var from, to string
+					sm := sourceMap{}
+					varFiles, err := matchVarFiles(target, env, deployConfig(env).VarFiles, envInterpolate)
+					if err != nil {
+						return envError(env, err)
+					}
+					encrypted := false
+					for _, vf := range varFiles {
+						to := filepath.Join(dest, vf.name)
+						if vf.encrypted {
+							encrypted = true
+							if err := utils.Copy(vf.src, filepath.Join(dest, encryptedDir, vf.name)); err != nil {
+								return fmt.Errorf("copying encrypted var file: %w", err)
+							}
+						} else if err := utils.Copy(vf.src, to); err != nil {
+							return fmt.Errorf("copying var file: %w", err)
+						}
+						place(vf.src, to)
+						sm.add(dest, to, target.StripCwd(vf.src))
+					}
 
-						if strings.HasSuffix(src, ".tfvars") || strings.HasSuffix(src, ".tfvars.json") {
-							i := slices.IndexFunc(varFilesFilter, func(item string) bool {
-								return strings.HasSuffix(src, item)
-							})
-							if i == -1 {
+					if len(vars[env]) > 0 {
+						if err := writeGeneratedVars(dest, vars[env], envInterpolate); err != nil {
+							return envError(env, err)
+						}
+					}
+
+					// the env srcs are placed on top of the common ones
+					names := []string{}
+					froms := map[string]string{}
+					for _, group := range []string{"_srcs", envSrcsPrefix + env} {
+						for _, src := range target.Srcs[group] {
+							if isVarFile(src) || slices.Contains(target.Srcs[backendPath], src) {
 								continue
 							}
 
-							name := filepath.Base(varFilesFilter[i])
-							from = src
-							if strings.HasSuffix(name, ".tfvars") {
-								to = filepath.Join(dest, fmt.Sprintf("%d-%s.auto.tfvars", i, strings.TrimSuffix(name, ".tfvars")))
-							} else if strings.HasSuffix(name, ".tfvars.json") {
-								to = filepath.Join(dest, fmt.Sprintf("%d-%s.auto.tfvars.json", i, strings.TrimSuffix(name, ".tfvars.json")))
+							name := filepath.Base(target.StripCwd(src))
+							scope, scoped, err := scopedOverride(name, scopes)
+							if err != nil {
+								return err
+							}
+							if scope != "" {
+								if scope != env && scope != baseEnvs[env] {
+									continue
+								}
+								name = scoped
+							}
+
+							if _, ok := froms[name]; !ok {
+								names = append(names, name)
 							}
-						} else {
-							from = src
-							to = filepath.Join(dest, filepath.Base(target.StripCwd(src)))
+							froms[name] = src
 						}
+					}
+
+					for _, name := range names {
+						from := froms[name]
+						to := filepath.Join(dest, name)
 
-						if err := utils.Copy(from, to); err != nil {
-							return fmt.Errorf("copying flattened src: %w", err)
+						if tc.InterpolateSrcs {
+							if err := copyInterpolated(target, tc.Interpolation, from, to, envInterpolate); err != nil {
+								return fmt.Errorf("copying flattened src: %w", err)
+							}
+						} else if filepath.Clean(from) != filepath.Clean(to) {
+							// without environments build runs in the sandbox the srcs were placed in, and copying a
+							// file onto itself truncates it
+							if err := utils.Copy(from, to); err != nil {
+								return fmt.Errorf("copying flattened src: %w", err)
+							}
 						}
+						sm.add(dest, to, target.StripCwd(from))
 					}
 
-					for _, src := range target.Srcs["providers"] {
+					for _, src := range append(append([]string{}, target.Srcs["providers"]...), target.Srcs["providers_"+env]...) {
 						from := src
 						to := filepath.Join(dest, filepath.Base(target.StripCwd(src)))
 
-						if err := target.Copy(from, to, envInterpolate); err != nil {
-							return fmt.Errorf("copying provider: %w", err)
+						if err := copyInterpolated(target, tc.Interpolation, from, to, envInterpolate); err != nil {
+							return envError(env, fmt.Errorf("copying provider: %w", err))
 						}
+						sm.add(dest, to, target.StripCwd(from))
 					}
 
 					for _, src := range target.Srcs[backendPath] {
 						from := src
 						to := filepath.Join(dest, fmt.Sprintf("_backend_%s", filepath.Base(target.StripCwd(src))))
 
-						if err := target.Copy(from, to, envInterpolate); err != nil {
-							return fmt.Errorf("copying backend: %w", err)
+						if err := copyInterpolated(target, tc.Interpolation, from, to, envInterpolate); err != nil {
+							return envError(env, fmt.Errorf("copying backend: %w", err))
+						}
+						place(from, to)
+						sm.add(dest, to, target.StripCwd(from))
+					}
+
+					tflintConfig := "tflint_config"
+					if _, ok := target.Srcs["tflint_config_"+env]; ok && env != "" {
+						tflintConfig = "tflint_config_" + env
+					}
+					for _, src := range target.Srcs[tflintConfig] {
+						to := filepath.Join(dest, tflintConfigFile)
+						if err := utils.Copy(src, to); err != nil {
+							return fmt.Errorf("copying tflint config: %w", err)
+						}
+						sm.add(dest, to, target.StripCwd(src))
+					}
+
+					for _, src := range target.Srcs["tests"] {
+						to := filepath.Join(dest, testsDir, filepath.Base(target.StripCwd(src)))
+						if err := utils.Copy(src, to); err != nil {
+							return fmt.Errorf("copying test: %w", err)
 						}
+						place(src, to)
+						sm.add(dest, to, target.StripCwd(src))
 					}
 
+					policies := map[string]string{}
+					for _, src := range target.Srcs["policies"] {
+						name := filepath.Base(target.StripCwd(src))
+						if other, ok := policies[name]; ok && other != src {
+							return fmt.Errorf("policies %s and %s have the same file name", target.StripCwd(other), target.StripCwd(src))
+						}
+						policies[name] = src
+
+						to := filepath.Join(dest, policiesDir, name)
+						if err := utils.Copy(src, to); err != nil {
+							return fmt.Errorf("copying policy: %w", err)
+						}
+					}
+
+					modules := [][]string{}
 					for _, label := range target.Labels {
 						if strings.HasPrefix(label, "module=") {
-							info := strings.Split(strings.TrimPrefix(label, "module="), "=")
-							from := filepath.Join(target.Cwd, info[0])
-							to := filepath.Join(dest, info[1])
-		
-							if err := utils.Link(from, to); err != nil { // we do not want to interpolate here
-								return fmt.Errorf("copying module %w", err)
-							}
+							modules = append(modules, strings.Split(strings.TrimPrefix(label, "module="), "="))
 						}
 					}
+					for _, mod := range envModules[env] {
+						modules = append(modules, []string{mod, filepath.Base(mod)})
+					}
+
+					for _, info := range modules {
+						from := filepath.Join(target.Cwd, info[0])
+						to := filepath.Join(dest, info[1])
+
+						if err := utils.Link(from, to); err != nil { // we do not want to interpolate here
+							return fmt.Errorf("copying module %w", err)
+						}
+						sm.add(dest, to, info[0])
+					}
+
+					if tags := defaultTags[env]; len(tags) > 0 {
+						provider := tc.DefaultTagsProvider
+						if provider == "" {
+							provider = "aws"
+						}
+
+						if err := writeDefaultTags(dest, provider, tags); err != nil {
+							return err
+						}
+					}
+
+					for _, from := range placed {
+						if err := os.Remove(from); err != nil && !os.IsNotExist(err) {
+							return fmt.Errorf("moving %s: %w", target.StripCwd(from), err)
+						}
+					}
+
+					if err := validateVariables(dest, sm, providedVariables(cliOpts(env), deployEnvNames, envVariables[env], tcc.Variables), !encrypted); err != nil {
+						return envError(env, err)
+					}
+
+					if err := sm.save(dest); err != nil {
+						return err
+					}
 				}
 
 				return nil
@@ -232,91 +586,264 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 			TransformOut: func(target *zen_targets.Target, o string) (string, bool) {
 				return filepath.Base(o), true
 			},
-			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+			Run: withDecryptedVars(ageKeyEnv, func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+				dc := deployConfig(runCtx.Env)
+
 				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
-				if err := tfInit(target, runCtx.Env); err != nil {
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
 					return fmt.Errorf("deploying: %s", err)
 				}
 
+				targets := append(append([]string{}, dc.Targets...), envList("ZEN_TF_TARGET")...)
+				replace := append(append([]string{}, dc.Replace...), envList("ZEN_TF_REPLACE")...)
+				args := targetingArgs(targets, replace)
+
+				var targeted string
+				if len(targets) > 0 {
+					targeted = fmt.Sprintf(" (TARGETED: %s)", strings.Join(targets, ", "))
+				}
+
+				// refused before planning, which would take the state lock
+				if !runCtx.DryRun && len(targets) > 0 && protectedEnvs[runCtx.Env] && !(dc.ForceTargeted != nil && *dc.ForceTargeted) && os.Getenv("ZEN_TF_FORCE_TARGETED") != "true" {
+					return fmt.Errorf("refusing targeted deploy on protected environment %s, set force_targeted or ZEN_TF_FORCE_TARGETED=true to override", runCtx.Env)
+				}
+
+				// a gated deploy applies the plan the policies were checked against
+				gated := len(tc.Policies) > 0 || len(requiredTags[runCtx.Env]) > 0
+				if gated {
+					planArgs := cliOpts(runCtx.Env).Plan.Args()
+					if !runCtx.DryRun {
+						planArgs = cliOpts(runCtx.Env).Apply.Args()
+					}
+
+					err := checkPlan(target, runCtx.Env, append(planArgs, args...), requiredTags[runCtx.Env])
+					defer os.Remove(filepath.Join(target.Cwd, planFile))
+					if err != nil {
+						return fmt.Errorf("deploying: %w", err)
+					}
+				}
+
 				if runCtx.DryRun {
-					target.SetStatus(fmt.Sprintf("Planning %s", target.Qn()))
-					if err := tfPlanApply(target, runCtx.Env); err != nil {
+					if gated {
+						return nil
+					}
+
+					target.SetStatus(fmt.Sprintf("Planning %s%s", target.Qn(), targeted))
+					if err := tfPlanApply(target, runCtx.Env, append(cliOpts(runCtx.Env).Plan.Args(), args...)...); err != nil {
 						return fmt.Errorf("deploying: %s", err)
 					}
 				} else {
-					target.SetStatus(fmt.Sprintf("Applying %s", target.Qn()))
-					if err := tfApply(target, runCtx.Env); err != nil && !tc.AllowFailure {
+					// sensitive outputs of the previous deploy may be echoed back by the apply. They are only read when the
+					// config declares some, and a first deploy has none to read
+					sensitive, err := hasSensitiveOutputs(target.Cwd)
+					if err != nil {
+						return fmt.Errorf("deploying: %w", err)
+					} else if sensitive {
+						if values, err := tfSensitiveOutputs(target, runCtx.Env); err == nil {
+							secretsOf(target).addValues(values...)
+						}
+					}
+
+					target.SetStatus(fmt.Sprintf("Applying %s%s", target.Qn(), targeted))
+					if gated {
+						err = tfApplyPlan(target, runCtx.Env, planFile, cliOpts(runCtx.Env).Apply.SavedPlanArgs()...)
+					} else {
+						err = tfApply(target, runCtx.Env, append(cliOpts(runCtx.Env).Apply.Args(), args...)...)
+					}
+					if err != nil && !(dc.AllowFailure != nil && *dc.AllowFailure) {
 						return fmt.Errorf("deploying: %s", err)
 					}
 				}
 
 				return nil
-			},
+			}),
 		},
 		"lint": {
-			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
-				if _, ok := target.Tools["tflint"]; !ok {
-					return fmt.Errorf("tflint is not configured")
-				}
-
-				target.SetStatus(fmt.Sprintf("Linting %s", target.Qn()))
-
-				return target.Exec([]string{target.Tools["tflint"]}, "tf lint")
-			},
+			Pre: preFunc,
+			Run: runLint(func(env string) string {
+				return deployConfig(env).TflintPluginDir
+			}),
+			Outs: append(append(reportOuts("lint"), reportOuts("secrets")...), "lint.json"),
+		},
+		"secrets": {
+			Run:  runSecrets,
+			Outs: reportOuts("secrets"),
+		},
+		"validate": {
+			Pre:  preFunc,
+			Run:  runValidate,
+			Outs: reportOuts("validate"),
+		},
+		"fmt": {
+			Run:  runFmt,
+			Outs: reportOuts("fmt"),
+		},
+		"security": {
+			Pre: preFunc,
+			Run: withDecryptedVars(ageKeyEnv, runSecurity(func(env string) bool {
+				sp := deployConfig(env).SecurityPlan
+				return sp != nil && *sp
+			}, func(env string) []string {
+				return cliOpts(env).Init.Args()
+			}, func(env string) []string {
+				return cliOpts(env).Plan.Args()
+			})),
+			Outs: reportOuts("security"),
+		},
+		"test": {
+			Pre:  preFunc,
+			Run:  withDecryptedVars(ageKeyEnv, runTests),
+			Outs: []string{"test.junit.xml", "test.json"},
 		},
 		"remove": {
 			Alias: []string{"rm", "del", "delete"},
 			Pre:   preFunc,
-			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+			Run: withDecryptedVars(ageKeyEnv, func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
 				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
-				if err := tfInit(target, runCtx.Env); err != nil {
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
 					return fmt.Errorf("destroying: %s", err)
 				}
 
 				if runCtx.DryRun {
 					target.SetStatus(fmt.Sprintf("Planning %s", target.Qn()))
-					if err := tfPlanDestroy(target, runCtx.Env); err != nil {
+					if err := tfPlanDestroy(target, runCtx.Env, cliOpts(runCtx.Env).Plan.Args()...); err != nil {
 						return fmt.Errorf("destroying: %s", err)
 					}
 				} else {
 					target.SetStatus(fmt.Sprintf("Applying %s", target.Qn()))
-					if err := tfDestroy(target, runCtx.Env); err != nil {
+					if err := tfDestroy(target, runCtx.Env, cliOpts(runCtx.Env).Destroy.Args()...); err != nil {
 						return fmt.Errorf("destroying: %s", err)
 					}
 				}
 
 				return nil
-			},
+			}),
 		},
 		"unlock": {
 			Pre: preFunc,
 			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
 				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
-				if err := tfInit(target, runCtx.Env); err != nil {
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
 					return fmt.Errorf("destroying: %s", err)
 				}
 
 				target.SetStatus(fmt.Sprintf("Planning should return lock info for %s", target.Qn()))
-				var executable string
-				if runCtx.Env == "local" {
-					executable = "tflocal"
-				} else {
-					executable = "terraform"
-				}
-
-				cmd := exec.Command(target.Tools[executable], "plan")
-				cmd.Dir = target.Cwd
-				cmd.Env = target.GetEnvironmentVariablesList()
-				out, err := cmd.Output()
+				out, err := terraformCombinedOutput(target, runCtx.Env, []string{"plan"})
 				if err == nil {
 					return fmt.Errorf("nothing to unlock, plan succeeded")
 				}
 
-				id := string(regexp.MustCompile(`ID:\s+([^\n]+)`).FindSubmatch(out)[1])
+				match := regexp.MustCompile(`ID:\s+([^\n]+)`).FindSubmatch(out)
+				if match == nil {
+					return fmt.Errorf("no lock info in the plan output:\n%s", secretsOf(target).redact(string(out)))
+				}
 
-				return terraformExec(target, runCtx.Env, []string{"force-unlock", "-force", id})
+				return terraformExec(target, runCtx.Env, []string{"force-unlock", "-force", string(match[1])})
 			},
 		},
+		"state_mv": {
+			Pre: preFunc,
+			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+				stateMoves := deployConfig(runCtx.Env).StateMoves
+				if len(stateMoves) == 0 {
+					return fmt.Errorf("no state_moves configured")
+				}
+
+				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
+					return fmt.Errorf("moving state: %w", err)
+				}
+
+				froms := []string{}
+				for from := range stateMoves {
+					froms = append(froms, from)
+				}
+				sort.Strings(froms)
+
+				for _, from := range froms {
+					target.SetStatus(fmt.Sprintf("Moving %s to %s in %s", from, stateMoves[from], target.Qn()))
+					if err := tfStateMv(target, runCtx.Env, from, stateMoves[from], runCtx.DryRun); err != nil {
+						return fmt.Errorf("moving state: %w", err)
+					}
+				}
+
+				return nil
+			},
+		},
+		"state_rm": {
+			Pre: preFunc,
+			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+				stateRemoves := deployConfig(runCtx.Env).StateRemoves
+				if len(stateRemoves) == 0 {
+					return fmt.Errorf("no state_removes configured")
+				}
+
+				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
+					return fmt.Errorf("removing state: %w", err)
+				}
+
+				for _, addr := range stateRemoves {
+					target.SetStatus(fmt.Sprintf("Removing %s from %s", addr, target.Qn()))
+					if err := tfStateRm(target, runCtx.Env, addr, runCtx.DryRun); err != nil {
+						return fmt.Errorf("removing state: %w", err)
+					}
+				}
+
+				return nil
+			},
+		},
+		"moved": {
+			Pre:  preFunc,
+			Outs: []string{movedFile},
+			Run: func(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+				target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
+				if err := tfInit(target, runCtx.Env, cliOpts(runCtx.Env).Init.Args()...); err != nil {
+					return fmt.Errorf("proposing moved blocks: %w", err)
+				}
+
+				// the srcs are compared with the ones of the ZEN_TF_MOVED_FROM git revision, the last commit by default
+				rev := os.Getenv("ZEN_TF_MOVED_FROM")
+				if rev == "" {
+					rev = "HEAD"
+				}
+
+				target.SetStatus(fmt.Sprintf("Comparing addresses for %s with %s", target.Qn(), rev))
+				previous, err := previousAddresses(target, target.Cwd, rev)
+				if err != nil {
+					return fmt.Errorf("proposing moved blocks: %w", err)
+				}
+
+				// the state also has the addresses of deploys made from older revisions
+				state, err := tfStateList(target, runCtx.Env)
+				if err != nil {
+					return fmt.Errorf("proposing moved blocks: %w", err)
+				}
+
+				current, err := parseResourceAddresses(target.Cwd)
+				if err != nil {
+					return fmt.Errorf("proposing moved blocks: %w", err)
+				}
+
+				moves, ambiguous := proposeMovedBlocks(normalizeStateAddresses(append(previous, state...)), current)
+				if err := writeMovedBlocks(target.Cwd, moves); err != nil {
+					return fmt.Errorf("proposing moved blocks: %w", err)
+				}
+				target.SetStatus(fmt.Sprintf("Proposed %d moved blocks for %s in %s", len(moves), target.Qn(), movedFile))
+
+				if len(ambiguous) > 0 {
+					return fmt.Errorf("could not infer where these addresses moved, write their moved blocks by hand: %s", strings.Join(ambiguous, ", "))
+				}
+
+				return nil
+			},
+		},
+	}
+
+	for _, tags := range requiredTags {
+		if len(tc.Policies) > 0 || len(tags) > 0 {
+			t.Scripts["deploy"].Outs = reportOuts("policy")
+		}
 	}
 
 	if tc.Deploy != nil {
@@ -332,8 +859,29 @@ func (tc TerraformConfig) GetTargets(tcc *zen_targets.TargetConfigContext) ([]*z
 			script.PassSecretEnv = tc.Deploy.SecretEnv
 		}
 
-		t.Scripts["deploy"].Outs = tc.Deploy.Outs
+		t.Scripts["deploy"].Outs = append(t.Scripts["deploy"].Outs, tc.Deploy.Outs...)
+	}
+
+	for _, script := range t.Scripts {
+		script.Run = withRedaction(script.Run)
 	}
 
 	return []*zen_targets.TargetBuilder{t}, nil
 }
+
+// lookupEnvVariable looks for a variable in the target environment first, falling back to the project environment
+func lookupEnvVariable(tcc *zen_targets.TargetConfigContext, env string, envConf *environs.Environment, key string) (string, bool) {
+	if envConf != nil {
+		if val, ok := envConf.Variables[key]; ok {
+			return val, true
+		}
+	}
+
+	if e, ok := tcc.Environments[env]; ok && e != nil {
+		if val, ok := e.Variables[key]; ok {
+			return val, true
+		}
+	}
+
+	return "", false
+}
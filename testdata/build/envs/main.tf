@@ -0,0 +1,4 @@
+module "network" {
+  source = "./network"
+  cidr   = var.cidr
+}
@@ -0,0 +1,3 @@
+module "network" {
+  nat_gateways = 0
+}
@@ -0,0 +1,24 @@
+variable "cidr" {
+  type = string
+}
+
+variable "owner" {
+  type = string
+}
+
+variable "tags" {
+  type    = map(string)
+  default = {}
+}
+
+variable "instance_count" {
+  type = number
+}
+
+variable "instance_types" {
+  type = list(string)
+}
+
+variable "labels" {
+  type = map(string)
+}
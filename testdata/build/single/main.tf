@@ -0,0 +1,5 @@
+resource "null_resource" "this" {}
+
+variable "name" {
+  type = string
+}
@@ -0,0 +1,53 @@
+// faketf stands in for terraform, tflocal and tflint in the tests. It records every call and replies with the
+// canned response for its command, if any
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type call struct {
+	Tool string   `json:"tool"`
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+}
+
+func main() {
+	dir, _ := os.Getwd()
+	data, _ := json.Marshal(call{Tool: os.Getenv("FAKETF_TOOL"), Args: os.Args[1:], Dir: dir})
+
+	f, err := os.OpenFile(os.Getenv("FAKETF_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(100)
+	}
+	f.Write(append(data, '\n'))
+	f.Close()
+
+	// the command is made of the arguments before the first flag, e.g. "state list"
+	command := []string{}
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		command = append(command, arg)
+	}
+	response := filepath.Join(os.Getenv("FAKETF_RESPONSES"), strings.Join(command, "_"))
+
+	if out, err := os.ReadFile(response + ".stdout"); err == nil {
+		os.Stdout.Write(out)
+	}
+	if out, err := os.ReadFile(response + ".stderr"); err == nil {
+		os.Stderr.Write(out)
+	}
+
+	if code, err := os.ReadFile(response + ".exit"); err == nil {
+		c, _ := strconv.Atoi(strings.TrimSpace(string(code)))
+		os.Exit(c)
+	}
+}
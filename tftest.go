@@ -0,0 +1,93 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// testsDir is where build places the .tftest.hcl files, the directory terraform test reads by default
+const testsDir = "tests"
+
+// testResult is the outcome of a single run block of a terraform test file
+type testResult struct {
+	File    string        `json:"file"`
+	Run     string        `json:"run"`
+	Status  string        `json:"status"`
+	Elapsed time.Duration `json:"elapsed"`
+	Output  string        `json:"output,omitempty"`
+}
+
+// writeTestReports writes test.json and test.junit.xml into the target cwd, with the secrets of the target masked
+func writeTestReports(target *zen_targets.Target, results []*testResult) error {
+	dir, name := target.Cwd, target.Qn()
+	for _, r := range results {
+		r.Run = secretsOf(target).redact(r.Run)
+		r.Output = secretsOf(target).redact(r.Output)
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding test results: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "test.json"), data, 0644); err != nil {
+		return fmt.Errorf("writing test results: %w", err)
+	}
+
+	suite := junitTestSuite{Name: name}
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      r.Run,
+			Classname: r.File,
+			Time:      r.Elapsed.Seconds(),
+			SystemOut: r.Output,
+		}
+
+		switch r.Status {
+		case "fail", "error":
+			tc.Failure = &junitFailure{Message: fmt.Sprintf("run %s: %s", r.Run, r.Status), Type: r.Status, Text: r.Output}
+			tc.SystemOut = ""
+		case "skip":
+			tc.Skipped = &junitSkipped{}
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	return writeJUnit(filepath.Join(dir, "test.junit.xml"), suite)
+}
+
+func runTests(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
+	if err := tfInit(target, runCtx.Env, "-backend=false"); err != nil {
+		return fmt.Errorf("testing: %w", err)
+	}
+
+	pw := newProgressWriter(target, "Testing")
+	runErr := pw.run(runCtx.Env, []string{"test"})
+
+	if err := writeTestReports(target, pw.tests); err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, r := range pw.tests {
+		if r.Status == "fail" || r.Status == "error" {
+			failed = append(failed, fmt.Sprintf("%s/%s", r.File, r.Run))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d test runs failed: %s", len(failed), len(pw.tests), strings.Join(failed, ", "))
+	} else if runErr != nil {
+		return fmt.Errorf("testing: %w", runErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// validateOutput is the result of terraform validate -json
+type validateOutput struct {
+	Valid        bool            `json:"valid"`
+	ErrorCount   int             `json:"error_count"`
+	WarningCount int             `json:"warning_count"`
+	Diagnostics  []*uiDiagnostic `json:"diagnostics"`
+}
+
+var tfValidate = func(target *zen_targets.Target, env string) (*validateOutput, error) {
+	out, execErr := terraformOutput(target, env, []string{"validate", "-json"})
+
+	var vo validateOutput
+	if err := json.Unmarshal(out, &vo); err != nil {
+		if execErr != nil {
+			return nil, fmt.Errorf("executing validate: %w", execErr)
+		}
+		return nil, fmt.Errorf("decoding validate output: %w", err)
+	}
+
+	return &vo, nil
+}
+
+// tfFmt formats a single file, only reporting whether it needs formatting when check is set
+var tfFmt = func(target *zen_targets.Target, file string, check bool) (bool, error) {
+	args := []string{"fmt", "-list=true"}
+	if check {
+		args = append(args, "-check")
+	}
+
+	out, err := terraformOutput(target, "", append(args, file))
+	if strings.TrimSpace(string(out)) != "" {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("formatting %s: %w", file, err)
+	}
+
+	return false, nil
+}
+
+func diagnosticFindings(ruleID string, diags []*uiDiagnostic) []finding {
+	findings := []finding{}
+	for _, d := range diags {
+		f := finding{
+			RuleID:  ruleID,
+			Level:   d.Severity,
+			Message: d.Summary,
+		}
+		if d.Detail != "" {
+			f.Message = fmt.Sprintf("%s: %s", d.Summary, d.Detail)
+		}
+		if d.Range != nil {
+			f.File = d.Range.Filename
+			f.Line = d.Range.Start.Line
+			f.Column = d.Range.Start.Column
+		}
+
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+// formattableSrcs returns the srcs terraform fmt understands
+func formattableSrcs(srcs []string) []string {
+	files := []string{}
+	for _, src := range srcs {
+		switch {
+		case strings.HasSuffix(src, ".tf"), strings.HasSuffix(src, ".tfvars"), strings.HasSuffix(src, ".tftest.hcl"):
+			files = append(files, src)
+		}
+	}
+
+	return files
+}
+
+func runValidate(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	target.SetStatus(fmt.Sprintf("Initializing %s", target.Qn()))
+	if err := tfInit(target, runCtx.Env, "-backend=false"); err != nil {
+		return fmt.Errorf("validating: %w", err)
+	}
+
+	target.SetStatus(fmt.Sprintf("Validating %s", target.Qn()))
+	vo, err := tfValidate(target, runCtx.Env)
+	if err != nil {
+		return fmt.Errorf("validating: %w", err)
+	}
+
+	sm := loadSourceMap(target.Cwd)
+	for _, d := range vo.Diagnostics {
+		sm.rewriteDiagnostic(d)
+	}
+
+	findings := diagnosticFindings("terraform-validate", vo.Diagnostics)
+	if err := writeReports(target, "validate", "terraform validate", findings); err != nil {
+		return err
+	}
+
+	if !vo.Valid {
+		return findingsError(findings, "%s is not valid", target.Qn())
+	}
+
+	return nil
+}
+
+// runFmt checks the formatting of the srcs. The original files are only formatted in place when ZEN_TF_FMT_WRITE=true
+// and it is not a dry run
+func runFmt(target *zen_targets.Target, runCtx *zen_targets.RuntimeContext) error {
+	write := os.Getenv("ZEN_TF_FMT_WRITE") == "true" && !runCtx.DryRun
+
+	findings := []finding{}
+	srcs := append([]string{}, target.Srcs["_srcs"]...)
+	groups := []string{}
+	for group := range target.Srcs {
+		if strings.HasPrefix(group, envSrcsPrefix) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		srcs = append(srcs, target.Srcs[group]...)
+	}
+
+	for _, src := range formattableSrcs(srcs) {
+		rel := target.StripCwd(src)
+		file := src
+		if write {
+			if target.Path() == "" {
+				return fmt.Errorf("cannot locate the original sources of %s", target.Qn())
+			}
+			file = filepath.Join(target.Path(), rel)
+		}
+
+		target.SetStatus(fmt.Sprintf("Formatting %s: %s", target.Qn(), rel))
+		changed, err := tfFmt(target, file, !write)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			level := "error"
+			if write {
+				level = "note"
+			}
+			findings = append(findings, finding{RuleID: "terraform-fmt", Level: level, Message: "file is not formatted", File: rel})
+		}
+	}
+
+	if err := writeReports(target, "fmt", "terraform fmt", findings); err != nil {
+		return err
+	}
+
+	if errs := countErrors(findings); errs > 0 {
+		files := []string{}
+		for _, f := range findings {
+			files = append(files, f.File)
+		}
+		return fmt.Errorf("%d files need formatting: %s", errs, strings.Join(files, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package terraform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+var variableSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "variable", LabelNames: []string{"name"}},
+	},
+}
+
+var variableBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "default"},
+	},
+}
+
+// variableDecl is a variable block of the root module
+type variableDecl struct {
+	Name     string
+	Type     cty.Type
+	Required bool
+	File     string
+}
+
+func parseTerraformFile(parser *hclparse.Parser, path string) (*hcl.File, bool, error) {
+	var file *hcl.File
+	var diags hcl.Diagnostics
+	isJSON := strings.HasSuffix(path, ".json")
+	if isJSON {
+		file, diags = parser.ParseJSONFile(path)
+	} else {
+		file, diags = parser.ParseHCLFile(path)
+	}
+	if diags.HasErrors() {
+		return nil, isJSON, fmt.Errorf("parsing %s: %s", path, diags.Error())
+	}
+
+	return file, isJSON, nil
+}
+
+// variableType evaluates a type constraint. In .tf.json files it is written as a string holding the expression
+func variableType(expr hcl.Expression, isJSON bool) (cty.Type, hcl.Diagnostics) {
+	if isJSON {
+		val, diags := expr.Value(nil)
+		if diags.HasErrors() {
+			return cty.NilType, diags
+		}
+		if val.Type() != cty.String || val.IsNull() {
+			return cty.NilType, hcl.Diagnostics{{Severity: hcl.DiagError, Summary: "type must be a string"}}
+		}
+
+		expr, diags = hclsyntax.ParseExpression([]byte(val.AsString()), "type", hcl.InitialPos)
+		if diags.HasErrors() {
+			return cty.NilType, diags
+		}
+	}
+
+	ty, _, diags := typeexpr.TypeConstraintWithDefaults(expr)
+	return ty, diags
+}
+
+// parseVariables returns the variables declared in the .tf and .tf.json files of dir
+func parseVariables(dir string) (map[string]*variableDecl, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	parser := hclparse.NewParser()
+	decls := map[string]*variableDecl{}
+	for _, entry := range entries {
+		if entry.IsDir() || !(strings.HasSuffix(entry.Name(), ".tf") || strings.HasSuffix(entry.Name(), ".tf.json")) {
+			continue
+		}
+
+		file, isJSON, err := parseTerraformFile(parser, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		content, _, diags := file.Body.PartialContent(variableSchema)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("decoding %s: %s", entry.Name(), diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			attrs, _, diags := block.Body.PartialContent(variableBlockSchema)
+			if diags.HasErrors() {
+				return nil, fmt.Errorf("decoding variable %s in %s: %s", block.Labels[0], entry.Name(), diags.Error())
+			}
+
+			decl := &variableDecl{Name: block.Labels[0], Type: cty.DynamicPseudoType, File: entry.Name()}
+			if attr, ok := attrs.Attributes["type"]; ok {
+				if decl.Type, diags = variableType(attr.Expr, isJSON); diags.HasErrors() {
+					return nil, fmt.Errorf("invalid type of variable %s in %s: %s", decl.Name, entry.Name(), diags.Error())
+				}
+			}
+			_, hasDefault := attrs.Attributes["default"]
+			decl.Required = !hasDefault
+
+			decls[decl.Name] = decl
+		}
+	}
+
+	return decls, nil
+}
+
+// parseVarFile returns the values assigned in a .tfvars or .tfvars.json file
+func parseVarFile(parser *hclparse.Parser, path string) (map[string]cty.Value, error) {
+	file, _, err := parseTerraformFile(parser, path)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("decoding %s: %s", path, diags.Error())
+	}
+
+	values := map[string]cty.Value{}
+	for name, attr := range attrs {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("evaluating %s in %s: %s", name, path, diags.Error())
+		}
+		values[name] = val
+	}
+
+	return values, nil
+}
+
+// validateVariables checks the var files in dir against the variables declared in it: every value must belong
+// to a declared variable and match its type, and when checkRequired is set every required variable must be set
+// by a var file or provided some other way (-var options or TF_VAR_ environment variables)
+func validateVariables(dir string, sm sourceMap, provided map[string]bool, checkRequired bool) error {
+	decls, err := parseVariables(dir)
+	if err != nil {
+		return err
+	}
+
+	varFiles, err := envVarFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	parser := hclparse.NewParser()
+	problems := []string{}
+	set := map[string]bool{}
+	for _, vf := range varFiles {
+		values, err := parseVarFile(parser, filepath.Join(dir, vf))
+		if err != nil {
+			return err
+		}
+
+		names := []string{}
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			set[name] = true
+
+			decl, ok := decls[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: variable %s is not declared", sm.resolve(vf), name))
+			} else if _, err := convert.Convert(values[name], decl.Type); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: variable %s must be %s: %s", sm.resolve(vf), name, typeexpr.TypeString(decl.Type), err))
+			}
+		}
+	}
+
+	required := []string{}
+	for name, decl := range decls {
+		if checkRequired && decl.Required && !set[name] && !provided[name] {
+			required = append(required, fmt.Sprintf("%s: required variable %s is not set", sm.resolve(decl.File), name))
+		}
+	}
+	sort.Strings(required)
+
+	if problems = append(problems, required...); len(problems) > 0 {
+		return fmt.Errorf("invalid variables:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+// providedVariables returns the variables set outside of the var files, through the -var options of the commands,
+// the TF_VAR_<name> environment variables passed to them or the ones zen sets from the variables
+func providedVariables(opts *CliOptions, envNames []string, variables ...map[string]string) map[string]bool {
+	provided := map[string]bool{}
+	if opts != nil {
+		for _, co := range []*CommandOptions{opts.Plan, opts.Apply, opts.Destroy} {
+			if co == nil {
+				continue
+			}
+			for k := range co.Vars {
+				provided[k] = true
+			}
+		}
+	}
+
+	for _, vars := range variables {
+		for name := range vars {
+			envNames = append(envNames, name)
+		}
+	}
+
+	for _, name := range envNames {
+		if strings.HasPrefix(name, "TF_VAR_") {
+			provided[strings.TrimPrefix(name, "TF_VAR_")] = true
+		}
+	}
+
+	return provided
+}
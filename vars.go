@@ -0,0 +1,155 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	zen_targets "github.com/zen-io/zen-core/target"
+)
+
+// envError prefixes err with the env it happened in, if any
+func envError(env string, err error) error {
+	if env == "" {
+		return err
+	}
+
+	return fmt.Errorf("env %s: %w", env, err)
+}
+
+type varFile struct {
+	src       string
+	name      string
+	encrypted bool
+}
+
+func isVarFile(src string) bool {
+	src = strings.TrimSuffix(src, ".age")
+	return strings.HasSuffix(src, ".tfvars") || strings.HasSuffix(src, ".tfvars.json")
+}
+
+// matchVarFiles finds the srcs of the var files and names them for the env directory. The index prefix keeps their
+// precedence, terraform loads .auto.tfvars files in lexical order
+func matchVarFiles(target *zen_targets.Target, env string, varFiles []string, vars map[string]string) ([]varFile, error) {
+	srcs := map[string]string{}
+	for _, src := range append(append([]string{}, target.Srcs["_srcs"]...), target.Srcs[envSrcsPrefix+env]...) {
+		if isVarFile(src) {
+			srcs[filepath.Clean(target.StripCwd(src))] = src
+		}
+	}
+
+	matched := []varFile{}
+	for i, v := range varFiles {
+		path, err := interpolateZen(v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("interpolating var file name: %w", err)
+		}
+
+		src, ok := srcs[filepath.Clean(path)]
+		if !ok {
+			return nil, fmt.Errorf("var file %s does not exist or is not part of srcs or env_srcs", path)
+		}
+
+		// age files are named after the var file they encrypt, sops ones are only recognisable by their contents
+		encrypted := strings.HasSuffix(path, ".age") || isSopsFile(src)
+		name := strings.TrimSuffix(filepath.Base(path), ".age")
+		if ext := ".tfvars.json"; strings.HasSuffix(name, ext) {
+			name = fmt.Sprintf("%02d-%s.auto%s", i, strings.TrimSuffix(name, ext), ext)
+		} else {
+			name = fmt.Sprintf("%02d-%s.auto.tfvars", i, strings.TrimSuffix(name, ".tfvars"))
+		}
+
+		matched = append(matched, varFile{src: src, name: name, encrypted: encrypted})
+	}
+
+	return matched, nil
+}
+
+// generatedVarsFile holds the configured vars of an env. It sorts after the numbered var files, so it takes precedence
+const generatedVarsFile = "zen_vars.auto.tfvars.json"
+
+// varVariablePrefix marks the environment variables that override a var, their value is decoded as JSON when possible
+const varVariablePrefix = "TERRAFORM_VAR_"
+
+func mergeVars(vars map[string]interface{}, overrides map[string]string) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range vars {
+		merged[k] = v
+	}
+
+	for k, v := range overrides {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+			merged[k] = decoded
+		} else {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+// interpolateValue interpolates every string in a var value, walking lists and maps
+func interpolateValue(value interface{}, vars map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return interpolateZen(v, vars)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			interpolated, err := interpolateValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = interpolated
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			interpolated, err := interpolateValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = interpolated
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			interpolated, err := interpolateValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = interpolated
+		}
+		return m, nil
+	default:
+		return value, nil
+	}
+}
+
+// writeGeneratedVars renders the vars of an env into its generated var file
+func writeGeneratedVars(dest string, vars map[string]interface{}, interpolateVars map[string]string) error {
+	rendered := map[string]interface{}{}
+	for k, v := range vars {
+		interpolated, err := interpolateValue(v, interpolateVars)
+		if err != nil {
+			return fmt.Errorf("interpolating var %s: %w", k, err)
+		}
+		rendered[k] = interpolated
+	}
+
+	data, err := json.MarshalIndent(rendered, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding vars: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dest, generatedVarsFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing vars: %w", err)
+	}
+
+	return nil
+}